@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// showWhyCut prints a minimum set of import edges that would need to
+// be removed so that no root package depends on any package matched
+// by whyMatch, followed by the number of edges in the set.
+func showWhyCut(w io.Writer, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	cut := minCut(allPkgs, rootPkgs, whyMatch)
+	for _, e := range cut {
+		fmt.Fprintf(w, "%s %s\n", e.from, e.to)
+	}
+	fmt.Fprintf(w, "cut size: %d\n", len(cut))
+}
+
+// showWhyFix prints the source position of each import statement
+// that would need to be removed to break the dependency of the root
+// packages on the packages matched by whyMatch, as found by minCut.
+func showWhyFix(w io.Writer, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	var lines []string
+	for _, e := range minCut(allPkgs, rootPkgs, whyMatch) {
+		sites := importSites[e]
+		if len(sites) == 0 {
+			warningf("no-import-site", e.from, "no source position known for import of %s by %s\n", e.to, e.from)
+			continue
+		}
+		for _, pos := range sites {
+			lines = append(lines, fmt.Sprintf("%s:%d %s", pos.Filename, pos.Line, e.to))
+		}
+	}
+	sort.Strings(lines)
+	for _, line := range uniq(lines) {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// importEdge represents an import of the package to by the package from.
+type importEdge struct {
+	from, to string
+}
+
+// minCut returns a minimum set of import edges which, if removed,
+// would leave no path from any root package to a package matched by
+// match. It treats each edge as having unit capacity and finds the
+// cut from the maximum flow between the roots and the matched
+// packages. The edges are returned sorted.
+func minCut(allPkgs map[string][]string, rootPkgs map[string]bool, match func(string) bool) []importEdge {
+	// Node 0 is the source, connected to all the roots, and node 1
+	// is the sink, connected from all the matched packages.
+	const source, sink = 0, 1
+	ids := map[string]int{}
+	names := []string{"", ""}
+	id := func(pkg string) int {
+		if n, ok := ids[pkg]; ok {
+			return n
+		}
+		ids[pkg] = len(names)
+		names = append(names, pkg)
+		return ids[pkg]
+	}
+	type arc struct {
+		from, to int
+	}
+	capacity := make(map[arc]int)
+	adj := make(map[int][]int)
+	addArc := func(from, to, c int) {
+		a := arc{from, to}
+		if _, ok := capacity[a]; !ok {
+			adj[from] = append(adj[from], to)
+			if _, ok := capacity[arc{to, from}]; !ok {
+				adj[to] = append(adj[to], from)
+				capacity[arc{to, from}] = 0
+			}
+		}
+		capacity[a] += c
+	}
+	var edges []importEdge
+	for _, pkg := range sorted(pkgSet(allPkgs)) {
+		if rootPkgs[pkg] {
+			continue
+		}
+		for _, importer := range uniq(sortedCopy(allPkgs[pkg])) {
+			edges = append(edges, importEdge{importer, pkg})
+		}
+	}
+	infinite := len(edges) + 1
+	for _, e := range edges {
+		if isXTestEdge(e) {
+			// There is no import statement to remove.
+			addArc(id(e.from), id(e.to), infinite)
+		} else {
+			addArc(id(e.from), id(e.to), 1)
+		}
+	}
+	for _, pkg := range sorted(rootPkgs) {
+		addArc(source, id(pkg), infinite)
+	}
+	for _, pkg := range sorted(pkgSet(allPkgs)) {
+		if match(pkg) && !rootPkgs[pkg] {
+			addArc(id(pkg), sink, infinite)
+		}
+	}
+	// reachable returns the nodes reachable from the source in the
+	// residual graph and, if the sink is reachable, the
+	// predecessor of each node on the path to it.
+	reachable := func() (map[int]bool, map[int]int) {
+		seen := map[int]bool{source: true}
+		pred := make(map[int]int)
+		queue := []int{source}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			for _, m := range adj[n] {
+				if seen[m] || capacity[arc{n, m}] <= 0 {
+					continue
+				}
+				seen[m] = true
+				pred[m] = n
+				queue = append(queue, m)
+			}
+		}
+		return seen, pred
+	}
+	for {
+		seen, pred := reachable()
+		if !seen[sink] {
+			var cut []importEdge
+			for _, e := range edges {
+				if seen[ids[e.from]] && !seen[ids[e.to]] {
+					cut = append(cut, e)
+				}
+			}
+			return cut
+		}
+		// All import edges have unit capacity, so every
+		// augmenting path carries exactly one unit of flow.
+		for n := sink; n != source; n = pred[n] {
+			capacity[arc{pred[n], n}]--
+			capacity[arc{n, pred[n]}]++
+		}
+	}
+}
+
+// pkgSet returns the set of all packages mentioned in allPkgs,
+// including importers that have no entry of their own.
+func pkgSet(allPkgs map[string][]string) map[string]bool {
+	pkgs := make(map[string]bool)
+	for pkg, importers := range allPkgs {
+		pkgs[pkg] = true
+		for _, importer := range importers {
+			pkgs[importer] = true
+		}
+	}
+	return pkgs
+}
+
+func sortedCopy(ss []string) []string {
+	ss = append([]string(nil), ss...)
+	sort.Strings(ss)
+	return ss
+}
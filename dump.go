@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// graphFile holds the dependency graph as written by -dump.
+type graphFile struct {
+	// Roots holds the packages named on the command line.
+	Roots []string `json:"roots"`
+	// Packages maps each package to the packages that import it.
+	Packages map[string][]string `json:"packages"`
+	// TestOnly holds imports made only by test code.
+	TestOnly []graphEdge `json:"testOnly,omitempty"`
+	// XTests maps each synthetic external test package added
+	// by -xtest-as-package to the package it tests.
+	XTests map[string]string `json:"xtests,omitempty"`
+}
+
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// writeGraph writes the given dependency graph to the named file.
+func writeGraph(path string, rootPkgs map[string]bool, allPkgs map[string][]string) {
+	g := graphFile{
+		Roots:    sorted(rootPkgs),
+		Packages: make(map[string][]string),
+	}
+	for _, pkg := range sorted(pkgSet(allPkgs)) {
+		importers, ok := allPkgs[pkg]
+		if !ok {
+			continue
+		}
+		g.Packages[pkg] = uniq(sortedCopy(importers))
+		for _, importer := range g.Packages[pkg] {
+			if testOnly[importEdge{importer, pkg}] {
+				g.TestOnly = append(g.TestOnly, graphEdge{importer, pkg})
+			}
+		}
+	}
+	for xtest, tested := range xtestPkgs {
+		if _, ok := allPkgs[xtest]; ok {
+			if g.XTests == nil {
+				g.XTests = make(map[string]string)
+			}
+			g.XTests[xtest] = tested
+		}
+	}
+	data, err := json.Marshal(g)
+	if err != nil {
+		fatalf("cannot marshal graph: %v\n", err)
+	}
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		fatalf("cannot write graph: %v\n", err)
+	}
+}
+
+// readGraph reads a dependency graph written by writeGraph.
+func readGraph(path string) (map[string]bool, map[string][]string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatalf("cannot read graph: %v\n", err)
+	}
+	var g graphFile
+	if err := json.Unmarshal(data, &g); err != nil {
+		fatalf("cannot unmarshal graph from %q: %v\n", path, err)
+	}
+	rootPkgs := make(map[string]bool)
+	for _, pkg := range g.Roots {
+		rootPkgs[pkg] = true
+	}
+	allPkgs := g.Packages
+	if allPkgs == nil {
+		allPkgs = make(map[string][]string)
+	}
+	for _, e := range g.TestOnly {
+		testOnly[importEdge{e.From, e.To}] = true
+	}
+	for xtest, tested := range g.XTests {
+		xtestPkgs[xtest] = tested
+	}
+	return rootPkgs, allPkgs
+}
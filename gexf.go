@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// gexfDoc and the types below define the subset of the GEXF 1.2
+// format (see https://gexf.net) written by -gexf.
+type gexfDoc struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// writeGEXF writes the dependency graph of the given packages to w
+// as a GEXF document, with the same nodes and edges as written by
+// writeProto. Nodes record whether the package was named on the
+// command line and whether it is in the standard library; edges
+// record whether the import is made only by test code.
+func writeGEXF(w io.Writer, pkgs []string, allPkgs map[string][]string, rootPkgs map[string]bool) error {
+	nodes := make(map[string]bool)
+	for _, pkg := range pkgs {
+		nodes[pkg] = true
+		for _, importer := range allPkgs[pkg] {
+			nodes[importer] = true
+		}
+	}
+	g := gexfGraph{
+		DefaultEdgeType: "directed",
+		Attributes: []gexfAttributes{{
+			Class: "node",
+			Attributes: []gexfAttribute{
+				{ID: "root", Title: "root", Type: "boolean"},
+				{ID: "stdlib", Title: "stdlib", Type: "boolean"},
+			},
+		}, {
+			Class: "edge",
+			Attributes: []gexfAttribute{
+				{ID: "test", Title: "test", Type: "boolean"},
+			},
+		}},
+	}
+	index := make(map[string]string)
+	for i, pkg := range sorted(nodes) {
+		index[pkg] = strconv.Itoa(i)
+		g.Nodes = append(g.Nodes, gexfNode{
+			ID:    index[pkg],
+			Label: pkg,
+			AttValues: []gexfAttValue{
+				{For: "root", Value: strconv.FormatBool(rootPkgs[pkg])},
+				{For: "stdlib", Value: strconv.FormatBool(isStdlib(pkg))},
+			},
+		})
+	}
+	var edges []importEdge
+	for _, pkg := range pkgs {
+		for _, importer := range uniq(sortedCopy(allPkgs[pkg])) {
+			edges = append(edges, importEdge{importer, pkg})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].to < edges[j].to
+	})
+	for i, e := range edges {
+		g.Edges = append(g.Edges, gexfEdge{
+			ID:     strconv.Itoa(i),
+			Source: index[e.from],
+			Target: index[e.to],
+			AttValues: []gexfAttValue{
+				{For: "test", Value: strconv.FormatBool(testOnly[e])},
+			},
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+	if err := enc.Encode(gexfDoc{
+		XMLNS:   "http://gexf.net/1.2",
+		Version: "1.2",
+		Graph:   g,
+	}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// affectedRoots returns the root packages that are affected by the
+// changes made since the merge base of HEAD and the given git revision:
+// those that contain a changed Go file or that depend, directly or
+// indirectly, on a package that does. The allPkgs graph must hold
+// all the dependencies of the root packages.
+func affectedRoots(base string, allPkgs map[string][]string, rootPkgs map[string]bool) map[string]bool {
+	mergeBase, err := runGit("merge-base", base, "HEAD")
+	if err != nil {
+		fatalf("cannot find merge base with %q: %v\n", base, err)
+	}
+	top, err := runGit("rev-parse", "--show-toplevel")
+	if err != nil {
+		fatalf("cannot find top of git repository: %v\n", err)
+	}
+	out, err := runGit("diff", "--name-only", mergeBase)
+	if err != nil {
+		fatalf("cannot find changed files: %v\n", err)
+	}
+	changedDirs := make(map[string]bool)
+	for _, f := range strings.Split(out, "\n") {
+		if strings.HasSuffix(f, ".go") {
+			changedDirs[filepath.Join(top, filepath.Dir(f))] = true
+		}
+	}
+	marked := make(map[string]bool)
+	for dir := range changedDirs {
+		p, err := buildContext.ImportDir(dir, 0)
+		if err != nil {
+			// The package may have been deleted, or
+			// it may not be a Go package at all.
+			continue
+		}
+		if _, ok := allPkgs[p.ImportPath]; ok {
+			markImporters(p.ImportPath, allPkgs, marked)
+		}
+	}
+	affected := make(map[string]bool)
+	for pkg := range rootPkgs {
+		if marked[pkg] {
+			affected[pkg] = true
+		}
+	}
+	return affected
+}
+
+// importBlame returns a description of who added the first import
+// of the package to by the package from, and when, as reported by
+// git blame. It returns the empty string if the import cannot be
+// found.
+func importBlame(from, to string) string {
+	first, ok := firstPosition(importSites[importEdge{from, to}])
+	if !ok {
+		return ""
+	}
+	site := fmt.Sprintf("%s:%d", first.Filename, first.Line)
+	out, err := runGitIn(filepath.Dir(first.Filename), "blame", "--porcelain", "-L", fmt.Sprintf("%d,%d", first.Line, first.Line), "--", filepath.Base(first.Filename))
+	if err != nil {
+		warningf("blame", to, "cannot blame %s: %v\n", site, err)
+		return site
+	}
+	var author string
+	var when time.Time
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "author "):
+			author = strings.TrimPrefix(line, "author ")
+		case strings.HasPrefix(line, "author-time "):
+			if t, err := strconv.ParseInt(strings.TrimPrefix(line, "author-time "), 10, 64); err == nil {
+				when = time.Unix(t, 0).UTC()
+			}
+		}
+	}
+	return fmt.Sprintf("%s added by %s on %s", site, author, when.Format("2006-01-02"))
+}
+
+// runGit runs git with the given arguments in the current
+// directory and returns its output with surrounding
+// white space removed.
+func runGit(args ...string) (string, error) {
+	return runGitIn(cwd, args...)
+}
+
+// runGitIn is like runGit but runs git in the given directory.
+func runGitIn(dir string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// listPackage holds the parts of the "go list -json"
+// output for a package that showdeps uses.
+type listPackage struct {
+	ImportPath   string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+	DepOnly      bool
+	Error        *struct {
+		Err string
+	}
+}
+
+// goList runs "go list -deps" on the given package patterns and
+// returns the packages matched by the patterns along with all the
+// listed packages, indexed by import path. Unless -T is specified, the
+// dependencies of the root packages' tests are listed too.
+func goList(patterns []string) (map[string]bool, map[string]*listPackage) {
+	listed := make(map[string]*listPackage)
+	rootPkgs := make(map[string]bool)
+	for _, p := range runGoList(patterns) {
+		listed[p.ImportPath] = p
+		if !p.DepOnly {
+			rootPkgs[p.ImportPath] = true
+		}
+	}
+	if *noTestDeps {
+		return rootPkgs, listed
+	}
+	testPkgs := make(map[string]bool)
+	for pkg := range rootPkgs {
+		p := listed[pkg]
+		for _, imp := range append(p.TestImports, p.XTestImports...) {
+			if listed[imp] == nil && imp != "C" {
+				testPkgs[imp] = true
+			}
+		}
+	}
+	if len(testPkgs) > 0 {
+		for _, p := range runGoList(sorted(testPkgs)) {
+			if listed[p.ImportPath] == nil {
+				listed[p.ImportPath] = p
+			}
+		}
+	}
+	return rootPkgs, listed
+}
+
+// runGoList runs "go list -e -deps -json" on the given
+// arguments and returns the resulting packages.
+func runGoList(args []string) []*listPackage {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", append([]string{"list", "-e", "-deps", "-json"}, args...)...)
+	cmd.Dir = cwd
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		fatalf("go list failed: %v\n%s", err, stderr.Bytes())
+	}
+	var pkgs []*listPackage
+	dec := json.NewDecoder(&stdout)
+	for {
+		var p listPackage
+		if err := dec.Decode(&p); err == io.EOF {
+			break
+		} else if err != nil {
+			fatalf("cannot decode go list output: %v\n", err)
+		}
+		if p.Error != nil {
+			warningf("golist", p.ImportPath, "%s: %s\n", p.ImportPath, strings.TrimSpace(p.Error.Err))
+		}
+		pkgs = append(pkgs, &p)
+	}
+	return pkgs
+}
+
+// goListImports is like findImports except that it adds the imports
+// of the given package using the packages listed by goList instead of
+// finding them with the build package.
+func goListImports(pkgName string, recur bool, listed map[string]*listPackage, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	if pkgName == "C" {
+		return
+	}
+	p := listed[pkgName]
+	if p == nil {
+		warningf("not-found", pkgName, "cannot find %q in go list output\n", pkgName)
+		return
+	}
+	allPkgs[p.ImportPath] = allPkgs[p.ImportPath] // ensure the package has an entry.
+	imps := make(map[string]bool)
+	addPackages(imps, p.Imports)
+	if rootPkgs[p.ImportPath] && !*noTestDeps {
+		addPackages(imps, p.TestImports)
+		addPackages(imps, p.XTestImports)
+	}
+	for _, name := range sorted(imps) {
+		_, alreadyDone := allPkgs[name]
+		allPkgs[name] = append(allPkgs[name], p.ImportPath)
+		testOnly[importEdge{p.ImportPath, name}] = !contains(p.Imports, name)
+		if recur && !alreadyDone {
+			goListImports(name, recur, listed, allPkgs, rootPkgs)
+		}
+	}
+}
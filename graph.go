@@ -0,0 +1,295 @@
+package main
+
+import "sort"
+
+// forwardGraph returns a map from each package mentioned
+// in allPkgs to the packages that it imports.
+func forwardGraph(allPkgs map[string][]string) map[string][]string {
+	imports := make(map[string][]string)
+	for _, pkg := range sorted(pkgSet(allPkgs)) {
+		for _, importer := range uniq(sortedCopy(allPkgs[pkg])) {
+			imports[importer] = append(imports[importer], pkg)
+		}
+	}
+	return imports
+}
+
+// reachable returns the set of packages reachable from any of the
+// given packages in the imports graph, including the packages
+// themselves.
+func reachable(from []string, imports map[string][]string) map[string]bool {
+	seen := make(map[string]bool)
+	var visit func(pkg string)
+	visit = func(pkg string) {
+		if seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		for _, imp := range imports[pkg] {
+			visit(imp)
+		}
+	}
+	for _, pkg := range from {
+		visit(pkg)
+	}
+	return seen
+}
+
+// dropTestEdges removes from allPkgs all imports made only by test
+// code, along with any packages that are then no longer reachable
+// from the root packages.
+func dropTestEdges(allPkgs map[string][]string, rootPkgs map[string]bool) {
+	for pkg, importers := range allPkgs {
+		kept := importers[:0]
+		for _, importer := range importers {
+			if !testOnly[importEdge{importer, pkg}] {
+				kept = append(kept, importer)
+			}
+		}
+		allPkgs[pkg] = kept
+	}
+	reached := reachable(sorted(rootPkgs), forwardGraph(allPkgs))
+	for pkg := range allPkgs {
+		if !reached[pkg] {
+			delete(allPkgs, pkg)
+		}
+	}
+}
+
+// importDepths returns the length of the shortest import chain from
+// any root package to each package reachable from the roots. The
+// root packages themselves have depth zero.
+func importDepths(allPkgs map[string][]string, rootPkgs map[string]bool) map[string]int {
+	imports := forwardGraph(allPkgs)
+	depths := make(map[string]int)
+	queue := sorted(rootPkgs)
+	for _, pkg := range queue {
+		depths[pkg] = 0
+	}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, imp := range imports[pkg] {
+			if _, ok := depths[imp]; !ok {
+				depths[imp] = depths[pkg] + 1
+				queue = append(queue, imp)
+			}
+		}
+	}
+	return depths
+}
+
+// rootClosure returns the set of packages that the given root package
+// depends on, directly or indirectly, not including the root itself.
+// Only imports made by the root's own tests are followed; test
+// imports of other packages are not.
+func rootClosure(root string, imports map[string][]string) map[string]bool {
+	seen := make(map[string]bool)
+	var visit func(pkg string)
+	visit = func(pkg string) {
+		for _, imp := range imports[pkg] {
+			if seen[imp] || pkg != root && testOnly[importEdge{pkg, imp}] {
+				continue
+			}
+			seen[imp] = true
+			visit(imp)
+		}
+	}
+	visit(root)
+	delete(seen, root)
+	return seen
+}
+
+// restrictRoots returns the root packages for which keep returns
+// true. The other roots become ordinary packages: their test imports
+// are no longer followed and any of them that are not dependencies of
+// the remaining roots are deleted from allPkgs, along with any
+// packages that are then unreachable.
+func restrictRoots(allPkgs map[string][]string, rootPkgs map[string]bool, keep func(string) bool) map[string]bool {
+	roots := make(map[string]bool)
+	for pkg := range rootPkgs {
+		if keep(pkg) {
+			roots[pkg] = true
+		}
+	}
+	imports := forwardGraph(allPkgs)
+	reached := make(map[string]bool)
+	for root := range roots {
+		reached[root] = true
+		for pkg := range rootClosure(root, imports) {
+			reached[pkg] = true
+		}
+	}
+	for pkg, importers := range allPkgs {
+		if !reached[pkg] {
+			delete(allPkgs, pkg)
+			continue
+		}
+		kept := importers[:0]
+		for _, importer := range importers {
+			if !reached[importer] {
+				continue
+			}
+			if rootPkgs[importer] && !roots[importer] && testOnly[importEdge{importer, pkg}] {
+				continue
+			}
+			kept = append(kept, importer)
+		}
+		allPkgs[pkg] = kept
+	}
+	return roots
+}
+
+// stronglyConnected returns the strongly connected components of the
+// given packages in the imports graph, using Tarjan's algorithm. The
+// members of each component are in the same order as in pkgs, and
+// every component comes after all the components that it imports.
+func stronglyConnected(pkgs []string, imports map[string][]string) [][]string {
+	order := make(map[string]int)
+	for i, pkg := range pkgs {
+		order[pkg] = i
+	}
+	index := make(map[string]int)
+	lowlink := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var comps [][]string
+	var visit func(pkg string)
+	visit = func(pkg string) {
+		index[pkg] = len(index)
+		lowlink[pkg] = index[pkg]
+		stack = append(stack, pkg)
+		onStack[pkg] = true
+		for _, imp := range imports[pkg] {
+			if _, ok := index[imp]; !ok {
+				visit(imp)
+				if lowlink[imp] < lowlink[pkg] {
+					lowlink[pkg] = lowlink[imp]
+				}
+			} else if onStack[imp] && index[imp] < lowlink[pkg] {
+				lowlink[pkg] = index[imp]
+			}
+		}
+		if lowlink[pkg] != index[pkg] {
+			return
+		}
+		var members []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			members = append(members, top)
+			if top == pkg {
+				break
+			}
+		}
+		sort.Slice(members, func(i, j int) bool {
+			return order[members[i]] < order[members[j]]
+		})
+		comps = append(comps, members)
+	}
+	for _, pkg := range pkgs {
+		if _, ok := index[pkg]; !ok {
+			visit(pkg)
+		}
+	}
+	return comps
+}
+
+// directImports removes from allPkgs all imports except those made
+// by the root packages (or their external test packages when
+// -xtest-as-package is specified), along with the packages that are
+// then not imported at all, leaving the graph that would have been
+// found without -a.
+func directImports(allPkgs map[string][]string, rootPkgs map[string]bool) {
+	isRoot := func(pkg string) bool {
+		return rootPkgs[pkg] || rootPkgs[xtestPkgs[pkg]]
+	}
+	for pkg, importers := range allPkgs {
+		kept := importers[:0]
+		for _, importer := range importers {
+			if isRoot(importer) {
+				kept = append(kept, importer)
+			}
+		}
+		if len(kept) == 0 && !rootPkgs[pkg] {
+			delete(allPkgs, pkg)
+			continue
+		}
+		allPkgs[pkg] = kept
+	}
+}
+
+// dominators returns the immediate dominator of each of the n nodes
+// of the graph with the given successors, as reached from node 0,
+// using the algorithm of Cooper, Harvey and Kennedy ("A Simple, Fast
+// Dominance Algorithm"). Node 0 is its own immediate dominator, and
+// nodes that cannot be reached have -1.
+func dominators(n int, succ map[int][]int) []int {
+	// Number the reachable nodes in postorder.
+	post := make([]int, n)
+	for i := range post {
+		post[i] = -1
+	}
+	var order []int
+	seen := make([]bool, n)
+	var visit func(v int)
+	visit = func(v int) {
+		seen[v] = true
+		for _, s := range succ[v] {
+			if !seen[s] {
+				visit(s)
+			}
+		}
+		post[v] = len(order)
+		order = append(order, v)
+	}
+	visit(0)
+	preds := make(map[int][]int)
+	for v, ss := range succ {
+		if seen[v] {
+			for _, s := range ss {
+				preds[s] = append(preds[s], v)
+			}
+		}
+	}
+	idom := make([]int, n)
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for post[a] < post[b] {
+				a = idom[a]
+			}
+			for post[b] < post[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		// Visit the nodes in reverse postorder.
+		for i := len(order) - 2; i >= 0; i-- {
+			v := order[i]
+			d := -1
+			for _, p := range preds[v] {
+				if idom[p] < 0 {
+					continue
+				}
+				if d < 0 {
+					d = p
+				} else {
+					d = intersect(p, d)
+				}
+			}
+			if d != idom[v] {
+				idom[v] = d
+				changed = true
+			}
+		}
+	}
+	return idom
+}
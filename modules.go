@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rogpeppe/godeps/build"
+)
+
+// goMod holds information about a module read from its go.mod file.
+type goMod struct {
+	// dir holds the directory containing the go.mod file.
+	dir string
+	// path holds the module path.
+	path string
+	// replace maps the path of each replaced module
+	// to the path it is replaced with.
+	replace map[string]string
+}
+
+// goMods caches the result of findGoMod by directory.
+var goMods = make(map[string]*goMod)
+
+// packageModule returns the module containing the given package, or
+// nil if the package is not inside a module. It returns an error if
+// the package cannot be found.
+// A synthetic external test package is in the module of the
+// package it tests.
+func packageModule(pkg string) (*goMod, error) {
+	if tested, ok := xtestPkgs[pkg]; ok {
+		pkg = tested
+	}
+	p, err := buildContext.Import(pkg, cwd, build.FindOnly)
+	if err != nil {
+		return nil, err
+	}
+	if p.Dir == "" {
+		return nil, fmt.Errorf("no directory found for %q", pkg)
+	}
+	return findGoMod(p.Dir), nil
+}
+
+// findGoMod returns the module defined by the go.mod file in dir or
+// its closest parent directory that has one, or nil if there is none.
+func findGoMod(dir string) *goMod {
+	if m, ok := goMods[dir]; ok {
+		return m
+	}
+	m, err := readGoMod(dir)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		if parent := filepath.Dir(dir); parent != dir {
+			m = findGoMod(parent)
+		}
+	default:
+		warningf("gomod", "", "cannot read go.mod: %v\n", err)
+	}
+	goMods[dir] = m
+	return m
+}
+
+// readGoMod reads the go.mod file in the given directory.
+func readGoMod(dir string) (*goMod, error) {
+	f, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := &goMod{
+		dir:     dir,
+		replace: make(map[string]string),
+	}
+	inReplace := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := modFields(scanner.Text())
+		switch {
+		case len(fields) == 0:
+		case inReplace && fields[0] == ")":
+			inReplace = false
+		case inReplace:
+			m.addReplace(fields)
+		case len(fields) == 2 && fields[0] == "module":
+			m.path = fields[1]
+		case len(fields) == 2 && fields[0] == "replace" && fields[1] == "(":
+			inReplace = true
+		case fields[0] == "replace":
+			m.addReplace(fields[1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// addReplace adds the replacement described by the fields of a
+// replace directive, which take the form:
+//
+//	old [version] => new [version]
+func (m *goMod) addReplace(fields []string) {
+	for i, f := range fields {
+		if f == "=>" && i > 0 && i+1 < len(fields) {
+			m.replace[fields[0]] = fields[i+1]
+			return
+		}
+	}
+}
+
+// localModules returns the paths of the modules that the main
+// module (the one containing the current directory) replaces with
+// directories on the local file system.
+func localModules() []string {
+	m := findGoMod(cwd)
+	if m == nil {
+		return nil
+	}
+	var paths []string
+	for path, repl := range m.replace {
+		if isLocalPath(repl) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// isLocalPath reports whether the replacement path
+// in a replace directive refers to a local directory.
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") || filepath.IsAbs(p)
+}
+
+// modFields splits a go.mod line into its fields,
+// ignoring comments and unquoting quoted fields.
+func modFields(line string) []string {
+	if i := strings.Index(line, "//"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	for i, f := range fields {
+		if uf, err := strconv.Unquote(f); err == nil {
+			fields[i] = uf
+		}
+	}
+	return fields
+}
+
+// externalModules returns the set of paths of the modules that
+// contain the given third party packages, excluding any modules
+// that contain root packages.
+func externalModules(pkgs []string, rootPkgs map[string]bool) map[string]bool {
+	rootMods := make(map[string]bool)
+	for pkg := range rootPkgs {
+		if m, _ := packageModule(pkg); m != nil {
+			rootMods[m.path] = true
+		}
+	}
+	mods := make(map[string]bool)
+	for _, pkg := range pkgs {
+		if !isExternal(pkg) {
+			continue
+		}
+		if m, _ := packageModule(pkg); m != nil && !rootMods[m.path] {
+			mods[m.path] = true
+		}
+	}
+	return mods
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"sort"
+)
+
+// writeProto writes the dependency graph of the given packages to w
+// as a Graph message as defined in showdeps.proto. The encoding is
+// done by hand so that showdeps does not depend on a protobuf
+// library.
+func writeProto(w io.Writer, pkgs []string, allPkgs map[string][]string, rootPkgs map[string]bool) error {
+	nodes := make(map[string]bool)
+	for _, pkg := range pkgs {
+		nodes[pkg] = true
+		for _, importer := range allPkgs[pkg] {
+			nodes[importer] = true
+		}
+	}
+	paths := sorted(nodes)
+	index := make(map[string]int)
+	var buf []byte
+	for i, pkg := range paths {
+		index[pkg] = i
+		var node []byte
+		node = appendStringField(node, 1, pkg)
+		node = appendBoolField(node, 2, rootPkgs[pkg])
+		node = appendBoolField(node, 3, isStdlib(pkg))
+		buf = appendBytesField(buf, 1, node)
+	}
+	var edges []importEdge
+	for _, pkg := range pkgs {
+		for _, importer := range uniq(sortedCopy(allPkgs[pkg])) {
+			edges = append(edges, importEdge{importer, pkg})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].from != edges[j].from {
+			return edges[i].from < edges[j].from
+		}
+		return edges[i].to < edges[j].to
+	})
+	for _, e := range edges {
+		var edge []byte
+		edge = appendUintField(edge, 1, uint64(index[e.from]))
+		edge = appendUintField(edge, 2, uint64(index[e.to]))
+		buf = appendBytesField(buf, 2, edge)
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// Protobuf wire types.
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendVarint(buf []byte, x uint64) []byte {
+	for x >= 0x80 {
+		buf = append(buf, byte(x)|0x80)
+		x >>= 7
+	}
+	return append(buf, byte(x))
+}
+
+func appendTag(buf []byte, field, wireType int) []byte {
+	return appendVarint(buf, uint64(field)<<3|uint64(wireType))
+}
+
+// appendUintField appends a varint field, omitting it
+// if it holds the default zero value as proto3 does.
+func appendUintField(buf []byte, field int, x uint64) []byte {
+	if x == 0 {
+		return buf
+	}
+	buf = appendTag(buf, field, wireVarint)
+	return appendVarint(buf, x)
+}
+
+func appendBoolField(buf []byte, field int, b bool) []byte {
+	if !b {
+		return buf
+	}
+	return appendUintField(buf, field, 1)
+}
+
+func appendBytesField(buf []byte, field int, b []byte) []byte {
+	buf = appendTag(buf, field, wireBytes)
+	buf = appendVarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func appendStringField(buf []byte, field int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	return appendBytesField(buf, field, []byte(s))
+}
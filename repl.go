@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var replHelp = `
+commands:
+	why pattern   show dependency chains to packages matching pattern
+	from pkg      show the packages that import pkg
+	deps pkg      show the packages that pkg imports
+	rdeps pkg     show all the packages that depend on pkg
+	help          show this message
+	quit          exit
+`[1:]
+
+// runREPL reads commands from r, one per line, and writes the results
+// of querying the dependency graph to w until r is exhausted or a quit
+// command is given. The root packages should still be present in
+// allPkgs.
+func runREPL(r io.Reader, w *bufio.Writer, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	imports := forwardGraph(allPkgs)
+	// Chain finding expects the root packages to be absent
+	// and the importers sorted, as they are in main.
+	nonRoot := make(map[string][]string)
+	for pkg, importers := range allPkgs {
+		sort.Strings(importers)
+		if !rootPkgs[pkg] {
+			nonRoot[pkg] = importers
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(os.Stderr, "> ")
+		if !scanner.Scan() {
+			break
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		cmd, args := fields[0], fields[1:]
+		if cmd == "quit" || cmd == "exit" {
+			break
+		}
+		switch cmd {
+		case "why", "from", "deps", "rdeps", "help":
+		default:
+			fmt.Fprintf(w, "unknown command %q; try help\n", cmd)
+			w.Flush()
+			continue
+		}
+		if cmd != "help" && len(args) != 1 {
+			fmt.Fprintf(w, "%s takes exactly one argument; try help\n", cmd)
+			w.Flush()
+			continue
+		}
+		switch cmd {
+		case "why":
+			whyMatch = matchPattern(args[0])
+			showNReasonsWhy(w, args[0], nonRoot, rootPkgs)
+		case "from":
+			for _, pkg := range uniq(sortedCopy(allPkgs[args[0]])) {
+				fmt.Fprintln(w, pkg)
+			}
+		case "deps":
+			for _, pkg := range imports[args[0]] {
+				// An external test package may
+				// import the package it tests.
+				if pkg != args[0] {
+					fmt.Fprintln(w, pkg)
+				}
+			}
+		case "rdeps":
+			marked := make(map[string]bool)
+			markImporters(args[0], allPkgs, marked)
+			delete(marked, args[0])
+			for _, pkg := range sorted(marked) {
+				fmt.Fprintln(w, pkg)
+			}
+		case "help":
+			w.WriteString(replHelp)
+		}
+		w.Flush()
+	}
+	if err := scanner.Err(); err != nil {
+		fatalf("cannot read commands: %v\n", err)
+	}
+}
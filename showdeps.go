@@ -2,44 +2,156 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"go/token"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/kisielk/gotool"
 	"github.com/rogpeppe/godeps/build"
 )
 
 var (
+	defPattern = flag.String("default-pattern", "", "packages to use when none are named on the command line (default $SHOWDEPS_DEFAULT_PATTERN or \".\")")
 	noTestDeps = flag.Bool("T", false, "exclude test dependencies")
 	all        = flag.Bool("a", false, "show all dependencies recursively (only test dependencies from the root packages are shown); when used with -why, show all intermediate packages")
+	xtestAsPkg = flag.Bool("xtest-as-package", false, "treat the external test package of each package on the command line as a separate package named with a _test suffix")
 	std        = flag.Bool("stdlib", false, "show stdlib dependencies")
 	from       = flag.Bool("from", false, "show which dependencies are introduced by which packages")
 	why        = flag.String("why", "", "show only packages which import directly or indirectly the specified package (implies -a and -from)")
 	files      = flag.Bool("f", false, "list Go source files instead of packages (overrides -from and -why)")
+	fileGlob   = flag.String("file-glob", "", "with -f, list only files whose names match the specified glob pattern")
 	maxChain   = flag.Int("n", 1, "max number of dependencies to print with -why (0 implies unlimited)")
+	dump       = flag.String("dump", "", "write the dependency graph to the specified file for later use with -load")
+	load       = flag.String("load", "", "read the dependency graph from the specified file (written by -dump) instead of finding it")
+	repl       = flag.Bool("repl", false, "find all dependencies (implies -a), then answer queries read from standard input")
+	protoOut   = flag.Bool("proto", false, "print the dependency graph as a binary protocol buffer (see showdeps.proto)")
+	gexf       = flag.Bool("gexf", false, "print the dependency graph as a GEXF document")
+	warnJSON   = flag.Bool("warnings-json", false, "print warnings to standard error as JSON objects")
+	jsonOut    = flag.Bool("json", false, "print a JSON object for each package")
+	edges      = flag.Bool("edges", false, "print one \"importer import\" pair for each dependency edge instead of packages")
+	golist     = flag.Bool("golist", false, "use \"go list\" to find packages and their imports")
+	commonDeps = flag.Bool("common-deps", false, "show only dependencies shared by every package on the command line (implies -a)")
+	uniqueDeps = flag.Bool("unique-deps", false, "show only dependencies not shared by every package on the command line (implies -a)")
+	rootCount  = flag.Bool("per-root-count", false, "print a table of the packages on the command line and the number of packages each one depends on (implies -a)")
+	impact     = flag.Bool("per-import-impact", false, "for each import made by a package on the command line, print the dependencies that would be lost without it (implies -a)")
+	matrix     = flag.Bool("matrix", false, "print a grid showing which packages on the command line depend on which others (implies -a)")
+	assertPure = flag.String("assert-no-external", "", "check that the specified package has no third party dependencies, printing any that it has and failing if so")
+	withFanout = flag.Bool("with-fanout", false, "follow each package with the number of packages that import it")
+	firstSite  = flag.Bool("first-site", false, "print the first source position that imports each package")
+	base       = flag.String("base", "", "consider only packages on the command line affected by changes since the merge base with the specified git revision")
+	dryRun     = flag.Bool("dry-run", false, "print the packages that would be analyzed and the configuration used, without analyzing them")
+	depthHist  = flag.Bool("depth-histogram", false, "print only the number of dependencies at each import depth from the packages on the command line (implies -a)")
+	exitCount  = flag.Bool("exit-count", false, "exit with a status holding the number of dependencies found (at most 255)")
+	hash       = flag.Bool("hash", false, "print only a SHA-256 hash of the sorted list of dependencies")
+	modCount   = flag.Bool("module-count", false, "print only the number of distinct third party modules containing all dependencies (implies -a)")
+	reqModules = flag.Bool("require-modules", false, "print a warning and fail for each non-stdlib dependency that is not inside a module")
+	langVer    = flag.String("lang-version", "", "evaluate build constraints as the specified Go version would (for example go1.21)")
+	goroot     = flag.String("goroot", "", "use the specified Go root directory instead of the default")
+	gopath     = flag.String("gopath", "", "use the specified GOPATH instead of the default")
+	findForks  = flag.Bool("find-forks", false, "report groups of packages that look like copies of the same package under different repositories")
+	external   = flag.Bool("external", false, "show only third party dependencies (excludes stdlib and -firstparty packages)")
+	localFirst = flag.Bool("local-as-firstparty", false, "treat modules replaced by local directories in go.mod as first party")
+	firstParty = stringsVar("firstparty", "import path prefix of first party packages (may be repeated; defaults to the current module's path)")
+	topo       = flag.Bool("topo", false, "print packages in topological order, dependencies before the packages that import them")
+	flame      = flag.Bool("flame", false, "print a folded-stack dependency chain for each package, suitable for flame graph tools")
+	whyDepth   = flag.Int("why-depth", 0, "max number of imports in each dependency chain printed with -why (0 implies unlimited)")
+	whyByDepth = flag.Bool("why-by-depth", false, "with -why, order chains by how far their target is from the root packages, furthest first")
+	whyNoTests = flag.Bool("why-no-tests", false, "with -why, ignore imports made by test code")
+	whySplit   = flag.String("why-split", "", "with -why, write the chains for each target package to a separate file in the specified directory")
+	whyDir     = flag.String("why-direction", "root-first", "with -why, print each chain starting from the package on the command line (root-first) or from the target package (target-first)")
+	whyTree    = flag.Bool("why-tree", false, "with -why, print dependency chains as an indented tree rooted at the target package")
+	whyModules = flag.Bool("why-module-count", false, "with -why, follow each chain with the number of third party modules it passes through")
+	whyResp    = flag.Bool("why-responsibility", false, "with -why, also print the number of packages that each root package depends on that lie on chains to the target")
+	whyMermaid = flag.Bool("why-mermaid", false, "with -why, print the dependency chains as a Mermaid flowchart")
+	whyBlame   = flag.Bool("why-blame", false, "with -why, show who added the import of the target package in each chain, and when, using git blame")
+	whyEdges   = flag.Bool("why-annotate-edges", false, "with -why, label each import in a chain as internal, boundary or external according to -firstparty")
+	whyLinks   = flag.Bool("why-links", false, "with -why, print third party packages in chains as pkg.go.dev links")
+	whyCut     = flag.Bool("why-cut", false, "with -why, show a minimum set of imports that must be removed to break the dependency, and their count")
+	whyFile    = flag.String("why-file", "", "read -why patterns from the specified file, one per line, and report which are depended on, failing if any are")
+	whyFix     = flag.Bool("why-fix", false, "with -why, print the source positions of a minimum set of import statements that must be removed to break the dependency")
+	whyPkgs    = flag.Bool("why-packages", false, "with -why, print only the set of packages that appear in any dependency chain, including the target packages")
+	rootPrefix = flag.String("why-root-prefix", "", "with -why, show only chains from packages on the command line under the specified import path prefix")
+	whyVia     = flag.String("why-via", "", "with -why, show only dependency chains that pass through a package matching the specified pattern")
+	hubLimit   = flag.Int("hub-limit", 0, "max number of importers of any one package to explore when finding -why chains (0 implies unlimited)")
+	showCycles = flag.Bool("why-show-cycles", false, "with -why, report import cycles encountered while finding dependency chains")
 )
 
 var exitCode = 0
 
+// depCount holds the number of dependencies found,
+// used as the exit status by -exit-count.
+var depCount = 0
+
 var whyMatch func(string) bool
 
+var viaMatch func(string) bool
+
+// testOnly holds imports that are made only by test code.
+var testOnly = make(map[importEdge]bool)
+
+// importSites holds the source positions of each import.
+var importSites = make(map[importEdge][]token.Position)
+
+// firstPartyPrefixes holds the import path prefixes
+// of the packages classified as first party.
+var firstPartyPrefixes []string
+
+// jsonPackage holds the information printed
+// about a package by the -json flag.
+type jsonPackage struct {
+	Path   string   `json:"path"`
+	From   []string `json:"from"`
+	Stdlib bool     `json:"stdlib,omitempty"`
+}
+
+// xtestPkgs maps the name of each synthetic external test
+// package added by -xtest-as-package to the package it tests.
+var xtestPkgs = make(map[string]string)
+
+// isXTestEdge reports whether e is the synthetic import of an
+// external test package by the package it tests, which does not
+// correspond to any import statement.
+func isXTestEdge(e importEdge) bool {
+	return xtestPkgs[e.to] == e.from && e.from != ""
+}
+
+// truncatedHubs holds packages that have had
+// importers ignored because of -hub-limit.
+var truncatedHubs = make(map[string]bool)
+
 var helpMessage = `
 usage: showdeps [flags] [pkg....]
 
 showdeps prints Go package dependencies of the named packages, specified
 as in the Go command (for instance ... wildcards work), one per line.
-If no packages are given, it uses the package in the current directory.
+If no packages are given, it uses the space-separated patterns given by
+the -default-pattern flag or, failing that, by the
+$SHOWDEPS_DEFAULT_PATTERN environment variable (for example "./..." to
+use the whole module by default). If neither is set, it uses the
+package in the current directory.
 
 Note that testing dependencies are only considered if they are
 in the packages specified on the command line. That is testing
 dependencies are not considered transitively.
 
+The -xtest-as-package flag causes the imports of the external test
+package (package foo_test) of each package on the command line to be
+attributed to a separate package whose import path is that of the
+package followed by "_test", imported by the package itself, so that
+dependencies only of black-box tests can be seen, for example with
+-from or -why. This is not supported with -golist or -f.
+
 By default it prints direct dependencies of the packages (and their tests)
 only, but the -a flag can be used to print all reachable dependencies.
 
@@ -49,38 +161,306 @@ by the paths of all the packages that depend on it.
 The -why flag finds out why a given dependency is present.  By default,
 it prints one arbitrary dependency chain for each package specified on
 the command line, showing why that package depends on the -why argument
-(which may also be a Go-command-style ... wildcard pattern).  If the
-package does not depend on the -why argument, it will not be printed. If
-the -a flag is specified, all packages in in any dependency chain will
+(which may also be a Go-command-style ... wildcard pattern, in which
+case the chains are grouped under a line naming each matching package).
+If the package does not depend on the -why argument, it will not be
+printed. If the -a flag is specified, all packages in in any dependency chain will
 printed in -from style. The -n flag can be used to print up to a given
 maximum number of arbitrary dependency chains - every dependency chain
-printed will have at least one different package in it.
+printed will have at least one different package in it. When a package
+on the command line imports the -why package directly, only that single
+import is printed for it, marked "(direct import)".
+
+The -why-direction flag controls the order of the packages in each
+chain. By default (root-first) each chain starts with a package on the
+command line and ends with the target package. With target-first, the
+order is reversed, so each package is followed by a package that
+imports it.
+
+The -why-depth flag limits the chains to those with at most the given
+number of imports in them, so that only nearby explanations are shown.
+
+The -why-by-depth flag orders the chains by the length of the shortest
+chain that reaches their target package, so that chains to the most
+deeply buried targets are printed first. When -n limits the number of
+chains, the chains to the deepest targets are the ones kept.
+
+The -why-no-tests flag causes -why to ignore imports made by test
+code, so that only dependencies of non-test code are explained.
+
+The -why-tree flag prints the chains as an indented tree instead, with
+each target package at the top level and the packages that import it
+(leading up to the packages on the command line) indented beneath,
+so that chains sharing packages are printed only once.
+
+The -why-split flag writes the chains to files in the given directory
+instead of standard output, one file for each package that matches
+the -why argument, named after that package with slashes replaced by
+underscores.
+
+The -why-mermaid flag prints all the chains combined into a single
+Mermaid flowchart instead, with an arrow from each package to each
+package it imports and the packages matching the -why argument
+highlighted.
+
+The -why-blame flag prints, after each chain, the source position of
+the import of the target package at the end of the chain, along with
+the author and date of the commit that added it (as told by git blame).
+
+The -why-links flag causes third party packages (see -external) in
+dependency chains to be printed as https://pkg.go.dev links.
+
+The -why-annotate-edges flag labels each import in a chain according to
+whether the packages at its ends are first party (see -external): an
+import is "internal" when both are first party, "boundary" when a first
+party package imports one that is not, and "external" otherwise. Each
+import is printed as an arrow holding its label, for example
+"example.com/a -boundary-> golang.org/x/text" (or pointing the other
+way with -why-direction=target-first). It has no effect with -why-tree.
+
+The -why-module-count flag follows each chain with the number of
+distinct modules containing the third party packages in it (see
+-module-count).
+
+The -why-responsibility flag follows the chains with a table holding,
+for each package on the command line that depends on the -why argument,
+the number of its dependencies that lie on any dependency chain leading
+to it, those with the most first. This gives an idea of how much each
+of those packages contributes to the dependency. Dependencies reached
+only through other packages named on the command line are not counted.
+
+The -why-cut flag prints, instead of chains, a smallest set of import
+edges (as "importer import" pairs) that would all need to be removed
+so that no package on the command line depends on the -why argument,
+followed by the number of edges in the set.
+
+The -why-fix flag prints the same set of imports as source positions,
+one "file:line package" line for each import statement that would need
+to be deleted. Source positions are not known for graphs read with
+-load or found with -golist.
+
+The -why-packages flag prints, instead of chains, the sorted set of all
+packages that lie on any dependency chain from a package on the command
+line to the -why argument, including both ends of the chains.
+
+The -why-root-prefix flag restricts the chains to those starting at a
+package on the command line under the given import path prefix, so
+that for example ./... can be used but only the dependencies of one
+subsystem explained. Other packages on the command line are then
+treated like any other dependency, so chains may pass through them.
+
+The -why-file flag reads a list of packages (or wildcard patterns) from
+the given file, one per line, ignoring blank lines and lines starting
+with "#", and checks each of them as if given to -why. For each one,
+a line holding the pattern followed by "reachable" or "unreachable" is
+printed; a reachable pattern is followed by its dependency chains
+(indented, and limited by -n as usual). The exit status is non-zero if
+any of the patterns is reachable, so a list of forbidden dependencies
+can be checked in a single run.
+
+The -why-via flag restricts the printed chains to those that include at
+least one package matching its argument (which may also be a
+wildcard pattern).
+
+The -hub-limit flag limits the number of importers of any single
+package that are explored when looking for dependency chains, which
+can make -why much faster when some packages are imported by very
+many others. A warning is printed when the limit is reached, but it
+does not cause a non-zero exit status.
+
+If the -why-show-cycles flag is specified, any import cycle encountered
+while looking for dependency chains is reported after the chains.
+
+If the -first-site flag is specified, each package is followed by the
+source position (file:line) of the first import of that package,
+ordered by file name and then line.
+
+If the -with-fanout flag is specified, each package is followed by the
+number of distinct packages that import it, in parentheses.
+
+If the -json flag is specified, a JSON object is printed for each
+package instead, holding its import path ("path"), the packages that
+depend on it ("from") and, for packages in the standard library (see
+-stdlib), "stdlib": true.
+
+The -proto flag prints the dependency graph as a single Graph protocol
+buffer message in binary form; the message is defined in the
+showdeps.proto file in the showdeps source directory.
+
+The -gexf flag prints the same graph as a GEXF XML document, for use
+with graph analysis tools such as Gephi. Each node has "root" and
+"stdlib" attributes, and each edge has a "test" attribute that is true
+when the import is made only by test code. When used with -why, only
+the packages and edges on dependency chains are included.
+
+The -warnings-json flag causes each warning to be printed as a JSON
+object instead, holding its kind ("type"), its text ("message") and,
+when the warning is about a particular package, that package
+("package").
+
+If the -edges flag is specified, each line instead holds a single
+importer and a package that it imports. Each such pair is printed once
+only. When used with -why, only edges on dependency chains are printed.
+
+The -external flag restricts the output to third party packages: those
+outside the standard library and not first party. First party packages
+are those under any of the import path prefixes given by the
+-firstparty flag, which may be specified more than once; if it is not
+specified, the path of the module containing the current directory is
+used. If the -local-as-firstparty flag is specified,
+packages in modules that the current module replaces with local
+directories (using replace directives in its go.mod file) are also
+treated as first party.
+
+The -topo flag prints packages in an order in which every package
+comes after all the packages it imports, rather than alphabetically.
+The packages in each import cycle are reported to standard error
+(without causing a non-zero exit status) and printed together,
+alphabetically, once the others that they all import have been.
+
+The -flame flag prints, for each package, one dependency chain from a
+root package to that package in the "folded stack" format understood
+by flame graph tools (packages separated by semicolons followed by a
+count), so that the width of each package in the resulting graph
+shows how many packages are reached through it.
+
+The -find-forks flag reports (on standard error) groups of dependencies
+that have the same import path apart from their first two elements
+(typically the host and the owner of a repository), which often
+indicates that both a package and a fork of it are being used. These
+are only hints, so they do not cause a non-zero exit status.
+
+The -matrix flag prints a line for each package named on the command
+line, holding a column for each of those packages in the same order
+followed by the package path. A column holds "x" if the package
+depends (directly or indirectly) on the package for that column,
+"." if it does not, and "-" for the package itself.
+
+The -hash flag prints just a hexadecimal SHA-256 hash of the sorted
+dependency list (one package per line), which changes only when the set
+of dependencies changes.
+
+The -exit-count flag makes showdeps exit with a status equal to the
+number of dependencies found (the number of packages that would be
+printed without -f), so that a shell script can test it without
+parsing the output. Exit statuses are limited to 8 bits, so any number
+above 255 is reported as 255. The status replaces the usual status of
+1 after a warning. It cannot be used with flags that do not print a
+list of dependencies: -assert-no-external, -common-deps, -dry-run,
+-matrix, -per-import-impact, -per-root-count, -repl, -unique-deps and
+-why-file.
+
+The -per-root-count flag prints a table holding each package named on
+the command line followed by the number of packages that it depends on
+(directly or indirectly), those with the most dependencies first.
+
+The -common-deps flag prints only the dependencies that every package
+named on the command line depends on, directly or indirectly. The
+-unique-deps flag prints the others: those that only some of the
+packages depend on.
+
+The -per-import-impact flag prints a line for each package imported
+directly by a package on the command line, holding the importing
+package, the imported package, the number of dependencies that would
+disappear if that one import were removed, and those dependencies
+(including the imported package itself when nothing else imports it).
+Lines are ordered with the most costly imports first.
+
+The -depth-histogram flag prints just the number of dependencies at
+each depth, where the depth of a package is the length of the shortest
+import chain from a package on the command line to it, so the line
+"depth 1: N" gives the number of direct dependencies.
+
+The -module-count flag prints just the number of distinct modules
+(found from go.mod files) that hold third party dependencies, not
+counting the modules of the packages named on the command line.
+
+The -require-modules flag prints a warning for each dependency outside
+the standard library whose directory has no go.mod file in it or any
+parent directory, which usually means that it has been found in GOPATH
+rather than in the module cache, and makes showdeps exit with a non-zero
+status if there are any. Packages whose directory cannot be found (for
+example in a graph read with -load) are reported but do not cause a
+failure.
+
+The -assert-no-external flag checks that the given package has no
+third party dependencies (see -external), taking into account all its
+dependencies recursively. Any that are found are printed as warnings
+and showdeps exits with a non-zero status. Other packages on the
+command line are ignored.
+
+The -golist flag causes showdeps to find the packages and their imports
+by running "go list -deps -json" instead of reading the source itself,
+so that modules and build constraints are treated exactly as the go
+command treats them. The -goroot and -gopath flags do not apply to
+the packages found this way.
+
+The -lang-version flag causes release build constraints (such as
+"go1.21") to be evaluated as they would be by the given version of Go,
+so files requiring a later version are ignored.
+
+The -goroot and -gopath flags can be used to analyze packages using a
+different Go installation or workspace from the one in the environment.
+
+The -base flag restricts the packages named on the command line to
+those affected by the changes made since the common ancestor of HEAD
+and the given git revision (for example "main"): those that contain a
+changed Go file or that depend on a package that does. This is useful
+for reviewing only the dependencies affected by a pull request.
+
+The -dry-run flag prints the build configuration, the flags that have
+been set and the packages named on the command line (after wildcard
+expansion) and exits without finding any dependencies.
+
+The -dump flag writes the dependency graph that has been found to the
+given file as JSON, and the -load flag reads a graph written in that way
+instead of finding one, so the same graph can be queried many times
+(for example with -why or -from) without reading any source code. No
+packages should be named on the command line with -load. The graph
+written by -dump reflects the flags in effect, so -a and -stdlib will
+usually be wanted. Flags that need package source, such as -f, do not
+work with a loaded graph.
+
+The -repl flag finds all the dependencies once and then reads commands
+from standard input, one per line, printing the result of each. Type
+"help" for a list of commands. Include the -stdlib flag to be able to
+query standard library packages.
 
 If the -f flag is provided, instead of packages, showdeps will print all
 the Go source files in the package. It also includes the source of the
 packages specified directly on the command line, including their test
-files unless the -T flag is provided.
+files unless the -T flag is provided. The -file-glob flag restricts the
+files printed to those with names matching the given pattern (using
+the syntax of filepath.Match), for example "*_test.go".
 
 `[1:]
 
 var cwd string
 
-var (
-	buildContext = func() build.Context {
-		ctx := build.Default
-		ctx.MatchTag = func(tag string, neg bool) bool {
-			if build.KnownOS(tag) || build.KnownArch(tag) {
-				return true
-			}
-			// Fall back to default settings for all other tags.
-			return ctx.DefaultMatchTag(tag) != neg
+var buildContext = build.Default
+
+func init() {
+	buildContext.MatchTag = func(tag string, neg bool) bool {
+		if build.KnownOS(tag) || build.KnownArch(tag) {
+			return true
 		}
-		return ctx
-	}()
-)
+		// Fall back to default settings for all other tags.
+		// Use buildContext rather than a copy so that
+		// later changes (for example by -lang-version)
+		// are taken into account.
+		return buildContext.DefaultMatchTag(tag) != neg
+	}
+}
 
 func main() {
-	os.Exit(main1())
+	code := main1()
+	if *exitCount {
+		code = depCount
+		if code > 255 {
+			code = 255
+		}
+	}
+	os.Exit(code)
 }
 
 func main1() int {
@@ -92,15 +472,62 @@ func main1() int {
 	flag.Parse()
 	pkgs := flag.Args()
 	if len(pkgs) == 0 {
-		pkgs = []string{"."}
+		pattern := *defPattern
+		if pattern == "" {
+			pattern = os.Getenv("SHOWDEPS_DEFAULT_PATTERN")
+		}
+		pkgs = strings.Fields(pattern)
+		if len(pkgs) == 0 {
+			pkgs = []string{"."}
+		}
 	}
 	if d, err := os.Getwd(); err != nil {
 		fatalf("cannot get working directory: %v", err)
 	} else {
 		cwd = d
 	}
+	setBuildPaths()
+	if *langVer != "" {
+		tags, err := releaseTags(*langVer)
+		if err != nil {
+			fatalf("%v\n", err)
+		}
+		buildContext.ReleaseTags = tags
+	}
+	if _, err := filepath.Match(*fileGlob, ""); err != nil {
+		fatalf("invalid -file-glob pattern %q: %v\n", *fileGlob, err)
+	}
+	firstPartyPrefixes = *firstParty
+	if len(firstPartyPrefixes) == 0 {
+		if m := findGoMod(cwd); m != nil && m.path != "" {
+			firstPartyPrefixes = []string{m.path}
+		}
+	}
+	if *localFirst {
+		firstPartyPrefixes = append(firstPartyPrefixes, localModules()...)
+	}
+	if *exitCount && (*assertPure != "" || *commonDeps || *dryRun || *matrix || *impact || *rootCount || *repl || *uniqueDeps || *whyFile != "") {
+		fatalf("cannot use -exit-count with a flag that does not find a list of dependencies\n")
+	}
+	if *assertPure != "" {
+		assertNoExternal(*assertPure)
+		return exitCode
+	}
 	recur := false
 	showAllWhy := false
+	var whyTargets []string
+	if *whyFile != "" {
+		if *why != "" {
+			fatalf("cannot use -why with -why-file\n")
+		}
+		whyTargets = readPatterns(*whyFile)
+		recur = true
+		for _, target := range whyTargets {
+			if isStdlib(target) {
+				*std = true
+			}
+		}
+	}
 	if *why != "" {
 		recur = true
 		if *all {
@@ -111,24 +538,115 @@ func main1() int {
 			*std = true
 		}
 		whyMatch = matchPattern(*why)
-	} else {
+	} else if *whyFile == "" {
 		recur = *all
 	}
+	if *whyVia != "" {
+		viaMatch = matchPattern(*whyVia)
+	}
+	if *whyDir != "root-first" && *whyDir != "target-first" {
+		fatalf("invalid -why-direction %q (must be root-first or target-first)\n", *whyDir)
+	}
+	if *external {
+		*std = false
+	}
+	if *modCount || *depthHist || *impact || *matrix || *rootCount || *repl || *commonDeps || *uniqueDeps {
+		recur = true
+	}
 
-	pkgs = gotool.ImportPaths(pkgs)
-	rootPkgs := make(map[string]bool)
-	for _, pkg := range pkgs {
-		p, err := buildContext.Import(pkg, cwd, build.FindOnly)
-		if err != nil {
-			fatalf("cannot find %q: %v", pkg, err)
+	var rootPkgs map[string]bool
+	var allPkgs map[string][]string
+	if *load != "" {
+		if flag.NArg() > 0 {
+			fatalf("cannot name packages with -load\n")
 		}
-		rootPkgs[p.ImportPath] = true
-	}
-	allPkgs := make(map[string][]string)
-	for pkg := range rootPkgs {
-		if err := findImports(pkg, cwd, recur, allPkgs, rootPkgs); err != nil {
-			fatalf("cannot find imports from %q: %v", pkg, err)
+		if *base != "" {
+			fatalf("cannot use -base with -load\n")
+		}
+		if *golist {
+			fatalf("cannot use -golist with -load\n")
+		}
+		rootPkgs, allPkgs = readGraph(*load)
+		if *dryRun {
+			showDryRun(os.Stdout, rootPkgs)
+			return exitCode
+		}
+	} else {
+		var listed map[string]*listPackage
+		if *golist {
+			rootPkgs, listed = goList(pkgs)
+		} else {
+			pkgs = gotool.ImportPaths(pkgs)
+			rootPkgs = make(map[string]bool)
+			for _, pkg := range pkgs {
+				p, err := buildContext.Import(pkg, cwd, build.FindOnly)
+				if err != nil {
+					fatalf("cannot find %q: %v", pkg, err)
+				}
+				rootPkgs[p.ImportPath] = true
+			}
+		}
+		findAll := func(recur bool) map[string][]string {
+			allPkgs := make(map[string][]string)
+			for pkg := range rootPkgs {
+				if *golist {
+					goListImports(pkg, recur, listed, allPkgs, rootPkgs)
+				} else if err := findImports(pkg, cwd, recur, allPkgs, rootPkgs); err != nil {
+					fatalf("cannot find imports from %q: %v", pkg, err)
+				}
+			}
+			return allPkgs
+		}
+		if *base != "" {
+			// All dependencies are needed to find out which
+			// roots are affected, so find them once and then
+			// prune the graph to what was asked for.
+			allPkgs = findAll(true)
+			affected := affectedRoots(*base, allPkgs, rootPkgs)
+			rootPkgs = restrictRoots(allPkgs, rootPkgs, func(pkg string) bool {
+				return affected[pkg]
+			})
+			if !recur {
+				directImports(allPkgs, rootPkgs)
+			}
+		}
+		if *dryRun {
+			showDryRun(os.Stdout, rootPkgs)
+			return exitCode
 		}
+		if allPkgs == nil {
+			allPkgs = findAll(recur)
+		}
+	}
+	if *dump != "" {
+		writeGraph(*dump, rootPkgs, allPkgs)
+	}
+	if *repl {
+		runREPL(os.Stdin, bufio.NewWriter(os.Stdout), allPkgs, rootPkgs)
+		return exitCode
+	}
+	if *matrix {
+		showMatrix(os.Stdout, allPkgs, rootPkgs)
+		return exitCode
+	}
+	if *rootCount {
+		showRootCounts(os.Stdout, allPkgs, rootPkgs)
+		return exitCode
+	}
+	if *impact {
+		showImportImpact(os.Stdout, allPkgs, rootPkgs)
+		return exitCode
+	}
+	if *commonDeps || *uniqueDeps {
+		showSharedDeps(os.Stdout, allPkgs, rootPkgs, *commonDeps)
+		return exitCode
+	}
+	if *rootPrefix != "" && (whyMatch != nil || *whyFile != "") && !*files {
+		// Consider only the roots under the -why-root-prefix
+		// path. Other roots are treated like any other package.
+		rootPkgs = restrictRoots(allPkgs, rootPkgs, func(pkg string) bool {
+			return hasPathPrefix(pkg, *rootPrefix)
+		})
 	}
 	if !*files {
 		// Delete packages specified directly on the command line.
@@ -136,6 +654,9 @@ func main1() int {
 			delete(allPkgs, pkg)
 		}
 		if whyMatch != nil {
+			if *whyNoTests {
+				dropTestEdges(allPkgs, rootPkgs)
+			}
 			// Delete all packages that don't directly or indirectly import *why.
 			marked := make(map[string]bool)
 			for pkg := range allPkgs {
@@ -149,6 +670,20 @@ func main1() int {
 				}
 			}
 		}
+		if *external {
+			for pkg := range allPkgs {
+				if !isExternal(pkg) {
+					delete(allPkgs, pkg)
+				}
+			}
+		}
+	}
+
+	if *whyFile != "" && !*files {
+		w := bufio.NewWriter(os.Stdout)
+		defer w.Flush()
+		showWhyTargets(w, whyTargets, allPkgs, rootPkgs)
+		return exitCode
 	}
 
 	result := make([]string, 0, len(allPkgs))
@@ -159,10 +694,114 @@ func main1() int {
 	w := bufio.NewWriter(os.Stdout)
 	defer w.Flush()
 	sort.Strings(result)
+	if *reqModules && !*files {
+		for _, r := range result {
+			if isStdlib(r) {
+				continue
+			}
+			m, err := packageModule(r)
+			switch {
+			case err != nil:
+				// It's not known whether the package is
+				// in a module, so don't fail because of it.
+				reportf("not-found", r, "cannot check module of %s: %v\n", r, err)
+			case m == nil:
+				warningf("no-module", r, "%s is not inside a module\n", r)
+			}
+		}
+	}
+	if *findForks {
+		reportForks(result)
+	}
+	if *topo {
+		result = topoSort(result, allPkgs)
+	}
+	depCount = len(result)
+	if *files {
+		// The root packages are still present in -f mode.
+		for _, r := range result {
+			if rootPkgs[r] {
+				depCount--
+			}
+		}
+	}
+	if *hash && !*files {
+		h := sha256.New()
+		for _, r := range sortedCopy(result) {
+			fmt.Fprintln(h, r)
+		}
+		fmt.Fprintf(w, "%x\n", h.Sum(nil))
+		return exitCode
+	}
+	if *depthHist && !*files {
+		depths := importDepths(allPkgs, rootPkgs)
+		counts := make(map[int]int)
+		max := 0
+		for _, r := range result {
+			if d, ok := depths[r]; ok {
+				counts[d]++
+				if d > max {
+					max = d
+				}
+			}
+		}
+		for d := 1; d <= max; d++ {
+			fmt.Fprintf(w, "depth %d: %d\n", d, counts[d])
+		}
+		return exitCode
+	}
+	if *modCount && !*files {
+		fmt.Fprintln(w, len(externalModules(result, rootPkgs)))
+		return exitCode
+	}
+	if *protoOut && !*files {
+		if err := writeProto(w, result, allPkgs, rootPkgs); err != nil {
+			fatalf("cannot write output: %v\n", err)
+		}
+		return exitCode
+	}
+	if *gexf && !*files {
+		if err := writeGEXF(w, result, allPkgs, rootPkgs); err != nil {
+			fatalf("cannot write output: %v\n", err)
+		}
+		return exitCode
+	}
+	if *edges && !*files {
+		showEdges(w, result, allPkgs)
+		return exitCode
+	}
+	if *flame && !*files {
+		showFlame(w, result, allPkgs, rootPkgs)
+		return exitCode
+	}
+	if *whyFix && whyMatch != nil && !*files {
+		showWhyFix(w, allPkgs, rootPkgs)
+		return exitCode
+	}
+	if *whyCut && whyMatch != nil && !*files {
+		showWhyCut(w, allPkgs, rootPkgs)
+		return exitCode
+	}
+	if *whyPkgs && whyMatch != nil && !*files {
+		onChain := make(map[string]bool)
+		for _, r := range result {
+			onChain[r] = true
+			for _, importer := range allPkgs[r] {
+				if rootPkgs[importer] {
+					onChain[importer] = true
+				}
+			}
+		}
+		for _, pkg := range sorted(onChain) {
+			fmt.Fprintln(w, pkg)
+		}
+		return exitCode
+	}
 	if *why != "" && !showAllWhy && !*files {
-		showNReasonsWhy(w, allPkgs, rootPkgs)
+		showNReasonsWhy(w, *why, allPkgs, rootPkgs)
 		return exitCode
 	}
+	enc := json.NewEncoder(w)
 	for _, r := range result {
 		switch {
 		case *files:
@@ -175,11 +814,25 @@ func main1() int {
 				showFiles(w, pkg, pkg.TestGoFiles)
 				showFiles(w, pkg, pkg.XTestGoFiles)
 			}
+		case *jsonOut:
+			enc.Encode(jsonPackage{
+				Path:   r,
+				From:   uniq(sortedCopy(allPkgs[r])),
+				Stdlib: isStdlib(r),
+			})
 		case *from:
 			from := allPkgs[r]
 			sort.Strings(from)
 			from = uniq(from)
 			fmt.Fprintf(w, "%s %s\n", r, strings.Join(from, " "))
+		case *firstSite:
+			if pos, ok := firstImportSite(r, allPkgs); ok {
+				fmt.Fprintf(w, "%s %s:%d\n", r, pos.Filename, pos.Line)
+			} else {
+				fmt.Fprintln(w, r)
+			}
+		case *withFanout:
+			fmt.Fprintf(w, "%s (%d)\n", r, len(uniq(sortedCopy(allPkgs[r]))))
 		default:
 			fmt.Fprintln(w, r)
 		}
@@ -187,24 +840,329 @@ func main1() int {
 	return exitCode
 }
 
+// setBuildPaths configures the build context from the -goroot and
+// -gopath flags.
+func setBuildPaths() {
+	if *goroot != "" {
+		checkDir(*goroot)
+		if !isDir(filepath.Join(*goroot, "src", "runtime")) {
+			warningf("goroot", "", "%q does not look like a Go root: no src/runtime directory\n", *goroot)
+		}
+		buildContext.GOROOT = *goroot
+		gotool.DefaultContext.BuildContext.GOROOT = *goroot
+	}
+	if *gopath != "" {
+		for _, dir := range filepath.SplitList(*gopath) {
+			checkDir(dir)
+			if !isDir(filepath.Join(dir, "src")) {
+				warningf("gopath", "", "%q does not look like a GOPATH directory: no src directory\n", dir)
+			}
+		}
+		buildContext.GOPATH = *gopath
+		gotool.DefaultContext.BuildContext.GOPATH = *gopath
+	}
+}
+
+// releaseTags returns the release tags satisfied by the given Go
+// version, which takes the form "go1.N" or "1.N", optionally followed
+// by a patch number.
+func releaseTags(version string) ([]string, error) {
+	v := strings.TrimPrefix(version, "go")
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "1" {
+		return nil, fmt.Errorf("invalid Go version %q", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return nil, fmt.Errorf("invalid Go version %q", version)
+	}
+	if len(parts) == 3 {
+		if patch, err := strconv.Atoi(parts[2]); err != nil || patch < 0 {
+			return nil, fmt.Errorf("invalid Go version %q", version)
+		}
+	}
+	var tags []string
+	for i := 1; i <= minor; i++ {
+		tags = append(tags, fmt.Sprintf("go1.%d", i))
+	}
+	return tags, nil
+}
+
+// checkDir exits with an error if dir is not an existing directory.
+func checkDir(dir string) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		fatalf("%v\n", err)
+	}
+	if !info.IsDir() {
+		fatalf("%q is not a directory\n", dir)
+	}
+}
+
+func isDir(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// assertNoExternal prints a warning for each third
+// party package that the given package depends on.
+func assertNoExternal(pkg string) {
+	p, err := buildContext.Import(pkg, cwd, build.FindOnly)
+	if err != nil {
+		fatalf("cannot find %q: %v\n", pkg, err)
+	}
+	rootPkgs := map[string]bool{p.ImportPath: true}
+	allPkgs := make(map[string][]string)
+	if err := findImports(p.ImportPath, cwd, true, allPkgs, rootPkgs); err != nil {
+		fatalf("cannot find imports from %q: %v\n", pkg, err)
+	}
+	for _, dep := range sorted(pkgSet(allPkgs)) {
+		if dep != p.ImportPath && isExternal(dep) {
+			warningf("external", dep, "%s depends on third party package %s\n", p.ImportPath, dep)
+		}
+	}
+}
+
+// showMatrix prints the dependency relation between
+// the root packages as a grid.
+func showMatrix(w io.Writer, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	roots := sorted(rootPkgs)
+	imports := forwardGraph(allPkgs)
+	for _, pkg := range roots {
+		deps := rootClosure(pkg, imports)
+		row := make([]byte, len(roots))
+		for i, dep := range roots {
+			switch {
+			case dep == pkg:
+				row[i] = '-'
+			case deps[dep]:
+				row[i] = 'x'
+			default:
+				row[i] = '.'
+			}
+		}
+		fmt.Fprintf(w, "%s %s\n", row, pkg)
+	}
+}
+
+// showRootCounts prints each root package along with the number of
+// packages in its dependency closure, largest first.
+func showRootCounts(w io.Writer, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	imports := forwardGraph(allPkgs)
+	roots := sorted(rootPkgs)
+	counts := make(map[string]int)
+	for _, pkg := range roots {
+		counts[pkg] = len(rootClosure(pkg, imports))
+	}
+	sort.SliceStable(roots, func(i, j int) bool {
+		return counts[roots[i]] > counts[roots[j]]
+	})
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, pkg := range roots {
+		fmt.Fprintf(tw, "%s\t%d\n", pkg, counts[pkg])
+	}
+	tw.Flush()
+}
+
+// showSharedDeps prints the dependencies that are in the dependency
+// closure of every root package if common is true, or the
+// dependencies that are in only some of them otherwise.
+func showSharedDeps(w io.Writer, allPkgs map[string][]string, rootPkgs map[string]bool, common bool) {
+	imports := forwardGraph(allPkgs)
+	counts := make(map[string]int)
+	for root := range rootPkgs {
+		for pkg := range rootClosure(root, imports) {
+			counts[pkg]++
+		}
+	}
+	for _, pkg := range sorted(pkgSet(allPkgs)) {
+		if counts[pkg] == 0 || rootPkgs[pkg] {
+			continue
+		}
+		if (counts[pkg] == len(rootPkgs)) == common {
+			fmt.Fprintln(w, pkg)
+		}
+	}
+}
+
+// showImportImpact prints, for each import made by a root package,
+// the dependencies of the root packages that are reached only
+// through that import.
+//
+// It builds a graph with a source node importing every root, in
+// which each import made by a root is split into a node of its own,
+// so that the packages lost when an import is removed are exactly
+// those dominated by its node.
+func showImportImpact(w io.Writer, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	type impact struct {
+		edge importEdge
+		node int
+		lost []string
+	}
+	imports := forwardGraph(allPkgs)
+	// split holds the packages whose imports are split into nodes:
+	// the roots and (with -xtest-as-package) their external
+	// test packages, whose imports are their own.
+	split := make(map[string]bool)
+	for root := range rootPkgs {
+		split[root] = true
+		for _, imp := range imports[root] {
+			if isXTestEdge(importEdge{root, imp}) {
+				split[imp] = true
+			}
+		}
+	}
+	// Node 0 is the source. Nodes for split imports have
+	// no name.
+	names := []string{""}
+	ids := make(map[string]int)
+	id := func(pkg string) int {
+		if n, ok := ids[pkg]; ok {
+			return n
+		}
+		ids[pkg] = len(names)
+		names = append(names, pkg)
+		return ids[pkg]
+	}
+	succ := make(map[int][]int)
+	for _, root := range sorted(rootPkgs) {
+		succ[0] = append(succ[0], id(root))
+	}
+	var impacts []impact
+	for _, pkg := range sorted(pkgSet(allPkgs)) {
+		for _, imp := range imports[pkg] {
+			e := importEdge{pkg, imp}
+			if !split[pkg] {
+				// Only the roots' own test imports are followed.
+				if !testOnly[e] {
+					succ[id(pkg)] = append(succ[id(pkg)], id(imp))
+				}
+				continue
+			}
+			n := len(names)
+			names = append(names, "")
+			succ[id(pkg)] = append(succ[id(pkg)], n)
+			succ[n] = append(succ[n], id(imp))
+			if imp != pkg && xtestPkgs[pkg] != imp && !isXTestEdge(e) {
+				// Imports of a package by its own tests
+				// cannot make its dependencies vanish.
+				impacts = append(impacts, impact{edge: e, node: n})
+			}
+		}
+	}
+	idom := dominators(len(names), succ)
+	children := make(map[int][]int)
+	for n, d := range idom {
+		if n != 0 && d >= 0 {
+			children[d] = append(children[d], n)
+		}
+	}
+	for i := range impacts {
+		var lost []string
+		var visit func(n int)
+		visit = func(n int) {
+			if pkg := names[n]; pkg != "" && !rootPkgs[pkg] {
+				lost = append(lost, pkg)
+			}
+			for _, c := range children[n] {
+				visit(c)
+			}
+		}
+		visit(impacts[i].node)
+		sort.Strings(lost)
+		impacts[i].lost = lost
+	}
+	sort.SliceStable(impacts, func(i, j int) bool {
+		return len(impacts[i].lost) > len(impacts[j].lost)
+	})
+	for _, imp := range impacts {
+		fmt.Fprintf(w, "%s %s %d", imp.edge.from, imp.edge.to, len(imp.lost))
+		for _, pkg := range imp.lost {
+			fmt.Fprintf(w, " %s", pkg)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// showDryRun prints the effective configuration and
+// the root packages that would be analyzed.
+func showDryRun(w io.Writer, rootPkgs map[string]bool) {
+	fmt.Fprintf(w, "GOOS %s\n", buildContext.GOOS)
+	fmt.Fprintf(w, "GOARCH %s\n", buildContext.GOARCH)
+	fmt.Fprintf(w, "GOROOT %s\n", buildContext.GOROOT)
+	fmt.Fprintf(w, "GOPATH %s\n", buildContext.GOPATH)
+	fmt.Fprintf(w, "tags %s\n", strings.Join(buildContext.BuildTags, ","))
+	flag.Visit(func(f *flag.Flag) {
+		fmt.Fprintf(w, "flag -%s=%s\n", f.Name, f.Value)
+	})
+	for _, pkg := range sorted(rootPkgs) {
+		fmt.Fprintf(w, "root %s\n", pkg)
+	}
+}
+
 // showNReasonsWhy shows up to maxChain lines for each package in the initial packages, each line showing
-// one dependency path from that package to a package matched by *why.
-func showNReasonsWhy(w io.Writer, allPkgs map[string][]string, rootPkgs map[string]bool) {
+// one dependency path from that package to a package matched by whyMatch,
+// which matches the given pattern.
+func showNReasonsWhy(w io.Writer, pattern string, allPkgs map[string][]string, rootPkgs map[string]bool) {
 	chains := make(map[string][][]string)
+	var cycles map[string]bool
+	var onCycle func(chain []string)
+	if *showCycles {
+		cycles = make(map[string]bool)
+		onCycle = func(chain []string) {
+			cycles[formatCycle(chain)] = true
+		}
+	}
+	// addChain adds a leaf-first dependency chain to chains,
+	// reversing it so that the root is first. It reports
+	// whether the chain was added.
+	addChain := func(chain []string) bool {
+		pkg := chain[len(chain)-1]
+		if *maxChain > 0 && len(chains[pkg]) >= *maxChain {
+			return false
+		}
+		if viaMatch != nil && !chainMatches(chain, viaMatch) {
+			return false
+		}
+		chains[pkg] = append(chains[pkg], reversed(chain))
+		return true
+	}
+	var targets []string
 	for pkg := range allPkgs {
-		if !whyMatch(pkg) {
-			continue
+		if whyMatch(pkg) {
+			targets = append(targets, pkg)
 		}
-		iterDepChains(pkg, rootPkgs, allPkgs, func(chain []string) {
-			pkg := chain[len(chain)-1]
-			if *maxChain > 0 && len(chains[pkg]) >= *maxChain {
-				return
+	}
+	sort.Strings(targets)
+	var depths map[string]int
+	if *whyByDepth {
+		// Find chains to the deepest targets first, so that
+		// they are the ones kept when -n limits the chains.
+		depths = importDepths(allPkgs, rootPkgs)
+		sort.SliceStable(targets, func(i, j int) bool {
+			return depths[targets[i]] > depths[targets[j]]
+		})
+	}
+	for _, pkg := range targets {
+		// When a root imports the target directly, that's the
+		// simplest explanation, so it's the only chain
+		// shown from that root to the target.
+		direct := make(map[string]bool)
+		for _, importer := range allPkgs[pkg] {
+			if rootPkgs[importer] && !direct[importer] {
+				direct[importer] = addChain([]string{pkg, importer})
 			}
-			chain1 := make([]string, len(chain))
-			for i, p := range chain {
-				chain1[len(chain)-i-1] = p
+		}
+		iterDepChains(pkg, rootPkgs, allPkgs, onCycle, func(chain []string) {
+			if !direct[chain[len(chain)-1]] {
+				addChain(chain)
 			}
-			chains[pkg] = append(chains[pkg], chain1)
+		})
+	}
+	for _, pkgChains := range chains {
+		// Show direct imports before any longer chains.
+		sort.SliceStable(pkgChains, func(i, j int) bool {
+			return len(pkgChains[i]) == 2 && len(pkgChains[j]) != 2
 		})
 	}
 	whyRoots := make([]string, 0, len(chains))
@@ -212,41 +1170,595 @@ func showNReasonsWhy(w io.Writer, allPkgs map[string][]string, rootPkgs map[stri
 		whyRoots = append(whyRoots, pkg)
 	}
 	sort.Strings(whyRoots)
+	var ordered [][]string
 	for _, pkg := range whyRoots {
-		for _, chain := range chains[pkg] {
-			fmt.Fprintf(w, "%s\n", strings.Join(chain, " "))
+		ordered = append(ordered, chains[pkg]...)
+	}
+	if *whyByDepth {
+		sort.SliceStable(ordered, func(i, j int) bool {
+			ti, tj := ordered[i][len(ordered[i])-1], ordered[j][len(ordered[j])-1]
+			if depths[ti] != depths[tj] {
+				return depths[ti] > depths[tj]
+			}
+			return ti < tj
+		})
+	}
+	switch {
+	case *whyMermaid:
+		writeMermaid(w, ordered)
+	case *whySplit != "":
+		splitChains(*whySplit, ordered)
+	case strings.Contains(pattern, "...") && !*whyTree:
+		// The pattern can match many packages, so group
+		// the chains under a heading for each one.
+		targets, byTarget := groupByTarget(ordered)
+		if !*whyByDepth {
+			sort.Strings(targets)
+		}
+		for _, target := range targets {
+			fmt.Fprintf(w, "%s:\n", target)
+			writeChains(w, byTarget[target], "\t")
 		}
+	default:
+		writeChains(w, ordered, "")
+	}
+	for _, cycle := range sorted(cycles) {
+		fmt.Fprintf(w, "chain passes through cycle %s\n", cycle)
+	}
+	if *whyResp {
+		showResponsibility(w, allPkgs, rootPkgs)
 	}
 	return
 }
 
+// showWhyTargets prints whether each of the given -why patterns
+// matches any dependency, followed by the dependency chains to the
+// ones that do, and sets the exit code if any does.
+func showWhyTargets(w io.Writer, targets []string, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	if *whyNoTests {
+		dropTestEdges(allPkgs, rootPkgs)
+	}
+	for _, target := range targets {
+		whyMatch = matchPattern(target)
+		reachable := false
+		for pkg := range allPkgs {
+			if whyMatch(pkg) {
+				reachable = true
+				break
+			}
+		}
+		if !reachable {
+			fmt.Fprintf(w, "%s unreachable\n", target)
+			continue
+		}
+		exitCode = 1
+		fmt.Fprintf(w, "%s reachable\n", target)
+		var buf bytes.Buffer
+		showNReasonsWhy(&buf, target, allPkgs, rootPkgs)
+		for _, line := range strings.SplitAfter(buf.String(), "\n") {
+			if line != "" {
+				fmt.Fprintf(w, "\t%s", line)
+			}
+		}
+	}
+	whyMatch = nil
+}
+
+// readPatterns reads the package patterns in the given
+// file, one per line, ignoring blank lines and comments.
+func readPatterns(file string) []string {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fatalf("%v\n", err)
+	}
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+// showResponsibility prints, for each root package that depends on a
+// package matched by whyMatch, the number of packages in its
+// dependency closure that lie on a dependency chain to any such
+// package, including the matched packages themselves.
+func showResponsibility(w io.Writer, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	onChain := make(map[string]bool)
+	for pkg := range allPkgs {
+		if whyMatch(pkg) && !rootPkgs[pkg] {
+			markImporters(pkg, allPkgs, onChain)
+		}
+	}
+	imports := forwardGraph(allPkgs)
+	var roots []string
+	counts := make(map[string]int)
+	for _, root := range sorted(rootPkgs) {
+		for pkg := range rootClosure(root, imports) {
+			if onChain[pkg] {
+				counts[root]++
+			}
+		}
+		if counts[root] > 0 {
+			roots = append(roots, root)
+		}
+	}
+	sort.SliceStable(roots, func(i, j int) bool {
+		return counts[roots[i]] > counts[roots[j]]
+	})
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, root := range roots {
+		fmt.Fprintf(tw, "%s\t%d packages on chains\n", root, counts[root])
+	}
+	tw.Flush()
+}
+
+// writeChains writes the given root-first dependency chains to w,
+// each line prefixed by indent unless -why-tree is specified.
+func writeChains(w io.Writer, chains [][]string, indent string) {
+	tree := &chainTree{}
+	for _, chain := range chains {
+		suffix := ""
+		if len(chain) == 2 {
+			suffix += " (direct import)"
+		}
+		if *whyModules {
+			suffix += fmt.Sprintf(" (%d third party modules)", len(chainModules(chain)))
+		}
+		blame := ""
+		if *whyBlame {
+			blame = importBlame(chain[len(chain)-2], chain[len(chain)-1])
+		}
+		var labels []string
+		if *whyEdges {
+			labels = edgeLabels(chain)
+		}
+		if *whyLinks {
+			chain = linkChain(chain)
+		}
+		if *whyTree {
+			tree.add(chain)
+			continue
+		}
+		arrow := " -%s-> "
+		if *whyDir == "target-first" {
+			chain = reversed(chain)
+			labels = reversed(labels)
+			arrow = " <-%s- "
+		}
+		line := strings.Join(chain, " ")
+		if labels != nil {
+			line = chain[0]
+			for i, label := range labels {
+				line += fmt.Sprintf(arrow, label) + chain[i+1]
+			}
+		}
+		fmt.Fprintf(w, "%s%s%s\n", indent, line, suffix)
+		if blame != "" {
+			fmt.Fprintf(w, "%s\t%s\n", indent, blame)
+		}
+	}
+	tree.write(w, "")
+}
+
+// edgeLabels returns a label for each import in the given
+// root-first chain, classifying it by whether its ends are
+// first party.
+func edgeLabels(chain []string) []string {
+	labels := make([]string, len(chain)-1)
+	for i := range labels {
+		switch {
+		case !isFirstParty(chain[i]):
+			labels[i] = "external"
+		case isFirstParty(chain[i+1]):
+			labels[i] = "internal"
+		default:
+			labels[i] = "boundary"
+		}
+	}
+	return labels
+}
+
+// reversed returns a copy of s in reverse order.
+func reversed(s []string) []string {
+	r := make([]string, len(s))
+	for i, x := range s {
+		r[len(s)-i-1] = x
+	}
+	return r
+}
+
+// writeMermaid writes the union of the given root-first dependency
+// chains as a Mermaid flowchart, with an arrow from each importing
+// package to each package it imports, and the target packages
+// highlighted.
+func writeMermaid(w io.Writer, chains [][]string) {
+	nodes := make(map[string]bool)
+	targets := make(map[string]bool)
+	edges := make(map[importEdge]bool)
+	for _, chain := range chains {
+		for i, pkg := range chain {
+			nodes[pkg] = true
+			if i > 0 {
+				edges[importEdge{chain[i-1], pkg}] = true
+			}
+		}
+		targets[chain[len(chain)-1]] = true
+	}
+	ids := make(map[string]string)
+	fmt.Fprintln(w, "flowchart LR")
+	for i, pkg := range sorted(nodes) {
+		ids[pkg] = fmt.Sprintf("n%d", i)
+		fmt.Fprintf(w, "\t%s[\"%s\"]\n", ids[pkg], pkg)
+	}
+	var lines []string
+	for e := range edges {
+		lines = append(lines, fmt.Sprintf("\t%s --> %s\n", ids[e.from], ids[e.to]))
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		io.WriteString(w, line)
+	}
+	fmt.Fprintln(w, "\tclassDef target fill:#f96,stroke:#333")
+	for _, pkg := range sorted(targets) {
+		fmt.Fprintf(w, "\tclass %s target\n", ids[pkg])
+	}
+}
+
+// groupByTarget groups the given root-first dependency chains by
+// their target package. It returns the targets in the order in which
+// they first appear in chains.
+func groupByTarget(chains [][]string) ([]string, map[string][][]string) {
+	var targets []string
+	byTarget := make(map[string][][]string)
+	for _, chain := range chains {
+		target := chain[len(chain)-1]
+		if byTarget[target] == nil {
+			targets = append(targets, target)
+		}
+		byTarget[target] = append(byTarget[target], chain)
+	}
+	return targets, byTarget
+}
+
+// splitChains writes the given dependency chains into files in dir,
+// one for each target package. Each file is named after its target
+// package, with slashes replaced by underscores.
+func splitChains(dir string, chains [][]string) {
+	_, byTarget := groupByTarget(chains)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		fatalf("%v\n", err)
+	}
+	for target, chains := range byTarget {
+		path := filepath.Join(dir, strings.Replace(target, "/", "_", -1))
+		f, err := os.Create(path)
+		if err != nil {
+			fatalf("%v\n", err)
+		}
+		w := bufio.NewWriter(f)
+		writeChains(w, chains, "")
+		if err := w.Flush(); err != nil {
+			fatalf("cannot write %q: %v\n", path, err)
+		}
+		if err := f.Close(); err != nil {
+			fatalf("cannot write %q: %v\n", path, err)
+		}
+	}
+}
+
+// chainTree holds a set of dependency chains
+// merged by their common target-end prefixes.
+type chainTree struct {
+	children map[string]*chainTree
+}
+
+// add adds a root-first dependency chain to the tree.
+func (t *chainTree) add(chain []string) {
+	for i := len(chain) - 1; i >= 0; i-- {
+		if t.children == nil {
+			t.children = make(map[string]*chainTree)
+		}
+		child := t.children[chain[i]]
+		if child == nil {
+			child = &chainTree{}
+			t.children[chain[i]] = child
+		}
+		t = child
+	}
+}
+
+// write writes the tree to w, one package per line,
+// indenting each level by two more spaces.
+func (t *chainTree) write(w io.Writer, indent string) {
+	names := make([]string, 0, len(t.children))
+	for name := range t.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s%s\n", indent, name)
+		t.children[name].write(w, indent+"  ")
+	}
+}
+
+// chainModules returns the set of modules containing
+// the third party packages in the given chain.
+func chainModules(chain []string) map[string]bool {
+	mods := make(map[string]bool)
+	for _, pkg := range chain {
+		if !isExternal(pkg) {
+			continue
+		}
+		if m, _ := packageModule(pkg); m != nil {
+			mods[m.path] = true
+		}
+	}
+	return mods
+}
+
+// linkChain returns a copy of chain with all
+// third party packages replaced by their pkg.go.dev URLs.
+func linkChain(chain []string) []string {
+	linked := make([]string, len(chain))
+	for i, pkg := range chain {
+		if isExternal(pkg) {
+			pkg = "https://pkg.go.dev/" + pkg
+		}
+		linked[i] = pkg
+	}
+	return linked
+}
+
+// formatCycle returns a printable form of the import cycle at the end
+// of the given chain, which must end with a package that occurs
+// earlier in the chain. The cycle is rotated so that it
+// starts with its lexically smallest package, so that the same
+// cycle found from different places always prints the same.
+func formatCycle(chain []string) string {
+	pkg := chain[len(chain)-1]
+	start := 0
+	for chain[start] != pkg {
+		start++
+	}
+	// Reverse the chain so that each package imports the next.
+	var cycle []string
+	for i := len(chain) - 2; i >= start; i-- {
+		cycle = append(cycle, chain[i])
+	}
+	min := 0
+	for i, p := range cycle {
+		if p < cycle[min] {
+			min = i
+		}
+	}
+	cycle = append(cycle[min:], cycle[:min]...)
+	cycle = append(cycle, cycle[0])
+	return strings.Join(cycle, " -> ")
+}
+
 // iterDepChains calls f with dependency chains to the given leaf package. The function is called with
 // leaf first and its importers sequentially after it.
 // It does not call f with *all* dependency chains, just the first chain that
 // it encounters that leads to a given package.
-func iterDepChains(leaf string, rootPkgs map[string]bool, allPkgs map[string][]string, f func(chain []string)) {
+// If cycle is non-nil, it is called with any chain that leads back
+// to a package already in the chain; that package will be the last
+// element of the chain.
+func iterDepChains(leaf string, rootPkgs map[string]bool, allPkgs map[string][]string, cycle, f func(chain []string)) {
 	chain := make([]string, 1, len(allPkgs))
 	chain[0] = leaf
-	iterDepChains1(chain, make(map[string]bool), rootPkgs, allPkgs, f)
+	iterDepChains1(chain, false, make(map[visitKey]int), rootPkgs, allPkgs, cycle, f)
+}
+
+// visitKey holds the state in which a package is visited by
+// iterDepChains1: a package reached by a chain that has passed
+// through a -why-via package must be explored again even if it has
+// already been reached by a chain that has not.
+type visitKey struct {
+	pkg string
+	via bool
 }
 
-func iterDepChains1(chain []string, visited map[string]bool, rootPkgs map[string]bool, allPkgs map[string][]string, f func(chain []string)) {
+// iterDepChains1 is the recursive part of iterDepChains. The visited
+// map holds the shortest chain length at which each package has
+// been visited, so that when chain length is limited by -why-depth,
+// a package first found at the end of a long chain is explored
+// again if it is found via a shorter one. The via parameter
+// reports whether the chain so far includes a package matched
+// by viaMatch.
+func iterDepChains1(chain []string, via bool, visited map[visitKey]int, rootPkgs map[string]bool, allPkgs map[string][]string, cycle, f func(chain []string)) {
 	pkg := chain[len(chain)-1]
+	via = via || viaMatch != nil && viaMatch(pkg)
 	if rootPkgs[pkg] {
-		f(chain)
+		if *whyDepth <= 0 || len(chain)-1 <= *whyDepth {
+			f(chain)
+		}
 		return
 	}
-	if visited[pkg] {
+	key := visitKey{pkg, via}
+	if n, ok := visited[key]; ok && (*whyDepth <= 0 || n <= len(chain)) {
+		if cycle != nil && contains(chain[:len(chain)-1], pkg) {
+			cycle(chain)
+		}
 		return
 	}
-	visited[pkg] = true
-	for _, importer := range allPkgs[pkg] {
-		iterDepChains1(append(chain, importer), visited, rootPkgs, allPkgs, f)
+	if *whyDepth > 0 && len(chain) > *whyDepth {
+		// The chain cannot get any longer.
+		return
+	}
+	visited[key] = len(chain)
+	for i, importer := range allPkgs[pkg] {
+		if *hubLimit > 0 && i >= *hubLimit {
+			if !truncatedHubs[pkg] {
+				truncatedHubs[pkg] = true
+				reportf("hub-limit", pkg, "only %d of %d importers of %q explored\n", *hubLimit, len(allPkgs[pkg]), pkg)
+			}
+			break
+		}
+		iterDepChains1(append(chain, importer), via, visited, rootPkgs, allPkgs, cycle, f)
+	}
+}
+
+// chainMatches reports whether any package in the chain
+// is matched by match.
+func chainMatches(chain []string, match func(string) bool) bool {
+	for _, p := range chain {
+		if match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// showEdges prints an "importer import" line for every import of
+// the given packages, sorted by importer and then by import.
+func showEdges(w io.Writer, pkgs []string, allPkgs map[string][]string) {
+	var lines []string
+	for _, pkg := range pkgs {
+		for _, importer := range allPkgs[pkg] {
+			lines = append(lines, importer+" "+pkg)
+		}
+	}
+	sort.Strings(lines)
+	for _, line := range uniq(lines) {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// showFlame prints a folded stack line for each of the given
+// packages, holding the first dependency chain found from a root
+// package to it.
+func showFlame(w io.Writer, pkgs []string, allPkgs map[string][]string, rootPkgs map[string]bool) {
+	var lines []string
+	for _, pkg := range pkgs {
+		var stack []string
+		iterDepChains(pkg, rootPkgs, allPkgs, nil, func(chain []string) {
+			if stack != nil {
+				return
+			}
+			stack = make([]string, len(chain))
+			for i, p := range chain {
+				stack[len(chain)-i-1] = p
+			}
+		})
+		if stack != nil {
+			lines = append(lines, strings.Join(stack, ";")+" 1")
+		}
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// topoSort returns the given sorted packages ordered so that each one
+// comes after all the packages it imports. Where there is a choice,
+// packages are kept in their original order. The packages in each
+// import cycle are reported, and are kept together in their original
+// order at the point where the cycle as a whole can be ordered.
+func topoSort(pkgs []string, allPkgs map[string][]string) []string {
+	inSet := make(map[string]bool)
+	for _, pkg := range pkgs {
+		inSet[pkg] = true
+	}
+	imports := make(map[string][]string)
+	for _, pkg := range pkgs {
+		for _, importer := range uniq(sortedCopy(allPkgs[pkg])) {
+			if inSet[importer] {
+				imports[importer] = append(imports[importer], pkg)
+			}
+		}
+	}
+	comps := stronglyConnected(pkgs, imports)
+	comp := make(map[string]int)
+	for i, members := range comps {
+		for _, pkg := range members {
+			comp[pkg] = i
+		}
+		if len(members) > 1 || contains(imports[members[0]], members[0]) {
+			reportf("cycle", "", "import cycle prevents topological ordering of %s\n", strings.Join(members, " "))
+		}
+	}
+	// ndeps holds the number of imports of packages in other,
+	// unordered components made by each component.
+	ndeps := make(map[int]int)
+	for pkg, imps := range imports {
+		for _, imp := range imps {
+			if comp[imp] != comp[pkg] {
+				ndeps[comp[pkg]]++
+			}
+		}
+	}
+	done := make(map[int]bool)
+	result := make([]string, 0, len(pkgs))
+	for len(result) < len(pkgs) {
+		// Find the first package whose component has all its
+		// imports done. There must be one, because the
+		// components cannot form a cycle.
+		next := -1
+		for _, pkg := range pkgs {
+			if c := comp[pkg]; !done[c] && ndeps[c] == 0 {
+				next = c
+				break
+			}
+		}
+		done[next] = true
+		result = append(result, comps[next]...)
+		for _, pkg := range comps[next] {
+			for _, importer := range uniq(sortedCopy(allPkgs[pkg])) {
+				if inSet[importer] && comp[importer] != next {
+					ndeps[comp[importer]]--
+				}
+			}
+		}
+	}
+	return result
+}
+
+// reportForks reports (without failing) each group of non-stdlib
+// packages in pkgs that share all but the first two import
+// path elements.
+func reportForks(pkgs []string) {
+	groups := make(map[string][]string)
+	for _, pkg := range pkgs {
+		if isStdlib(pkg) {
+			continue
+		}
+		elems := strings.SplitN(pkg, "/", 3)
+		if len(elems) < 3 {
+			continue
+		}
+		groups[elems[2]] = append(groups[elems[2]], pkg)
+	}
+	var keys []string
+	for key, group := range groups {
+		if len(group) > 1 {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		reportf("fork", "", "possible forks: %s\n", strings.Join(groups[key], " "))
 	}
 }
 
 func showFiles(w io.Writer, pkg *build.Package, fs []string) {
 	for _, f := range fs {
+		if *fileGlob != "" {
+			if ok, _ := filepath.Match(*fileGlob, f); !ok {
+				continue
+			}
+		}
 		fmt.Fprintln(w, filepath.Join(pkg.Dir, f))
 	}
 }
@@ -280,6 +1792,29 @@ func isStdlib(pkg string) bool {
 	return !strings.Contains(strings.SplitN(pkg, "/", 2)[0], ".")
 }
 
+// isFirstParty reports whether pkg is first party code, as
+// determined by the -firstparty and -local-as-firstparty flags.
+func isFirstParty(pkg string) bool {
+	for _, prefix := range firstPartyPrefixes {
+		if hasPathPrefix(pkg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExternal reports whether pkg is a third party package.
+func isExternal(pkg string) bool {
+	return !isStdlib(pkg) && !isFirstParty(pkg)
+}
+
+// hasPathPrefix reports whether the import path p
+// is prefix or is inside the directory named by prefix.
+func hasPathPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
 // findImports recursively adds all imported packages by the given
 // package (packageName) to the allPkgs map.
 func findImports(packageName, dir string, recur bool, allPkgs map[string][]string, rootPkgs map[string]bool) error {
@@ -288,7 +1823,7 @@ func findImports(packageName, dir string, recur bool, allPkgs map[string][]strin
 	}
 	pkg, err := buildContext.Import(packageName, dir, 0)
 	if err != nil {
-		warningf("warning: cannot find %q: %v", packageName, err)
+		warningf("not-found", packageName, "cannot find %q: %v\n", packageName, err)
 		return nil
 	}
 	allPkgs[pkg.ImportPath] = allPkgs[pkg.ImportPath] // ensure the package has an entry.
@@ -300,21 +1835,96 @@ func findImports(packageName, dir string, recur bool, allPkgs map[string][]strin
 		}
 		_, alreadyDone := allPkgs[name]
 		allPkgs[name] = append(allPkgs[name], pkg.ImportPath)
+		addImportSites(pkg, name, rootPkgs[pkg.ImportPath])
+		testOnly[importEdge{pkg.ImportPath, name}] = !contains(pkg.Imports, name)
 		if recur && !alreadyDone {
 			if err := findImports(name, pkg.Dir, recur, allPkgs, rootPkgs); err != nil {
 				return err
 			}
 		}
 	}
+	if rootPkgs[pkg.ImportPath] && xtestAsPackage() && len(pkg.XTestImports) > 0 {
+		return findXTestImports(pkg, recur, allPkgs, rootPkgs)
+	}
 	return nil
 }
 
+// findXTestImports adds the imports of the external test package of
+// the given root package, attributing them to a synthetic package
+// named after the root with a "_test" suffix.
+func findXTestImports(pkg *build.Package, recur bool, allPkgs map[string][]string, rootPkgs map[string]bool) error {
+	xname := pkg.ImportPath + "_test"
+	xtestPkgs[xname] = pkg.ImportPath
+	allPkgs[xname] = append(allPkgs[xname], pkg.ImportPath)
+	testOnly[importEdge{pkg.ImportPath, xname}] = true
+	imps := make(map[string]bool)
+	addPackages(imps, pkg.XTestImports)
+	for _, name := range sorted(imps) {
+		_, alreadyDone := allPkgs[name]
+		allPkgs[name] = append(allPkgs[name], xname)
+		e := importEdge{xname, name}
+		importSites[e] = append(importSites[e], pkg.XTestImportPos[name]...)
+		if recur && !alreadyDone {
+			if err := findImports(name, pkg.Dir, recur, allPkgs, rootPkgs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// xtestAsPackage reports whether external test packages
+// should be treated as separate packages.
+func xtestAsPackage() bool {
+	return *xtestAsPkg && !*noTestDeps && !*files && !*golist
+}
+
+// addImportSites records the source positions
+// of the imports of name by pkg.
+func addImportSites(pkg *build.Package, name string, isRoot bool) {
+	e := importEdge{pkg.ImportPath, name}
+	importSites[e] = append(importSites[e], pkg.ImportPos[name]...)
+	if isRoot && !*noTestDeps {
+		importSites[e] = append(importSites[e], pkg.TestImportPos[name]...)
+		if !xtestAsPackage() {
+			importSites[e] = append(importSites[e], pkg.XTestImportPos[name]...)
+		}
+	}
+}
+
+// firstImportSite returns the first source position, ordered by file
+// name and line, that imports the given package.
+func firstImportSite(pkg string, allPkgs map[string][]string) (token.Position, bool) {
+	var sites []token.Position
+	for _, importer := range allPkgs[pkg] {
+		sites = append(sites, importSites[importEdge{importer, pkg}]...)
+	}
+	return firstPosition(sites)
+}
+
+// firstPosition returns the first of the given
+// positions, ordered by file name and line.
+func firstPosition(ps []token.Position) (token.Position, bool) {
+	if len(ps) == 0 {
+		return token.Position{}, false
+	}
+	first := ps[0]
+	for _, pos := range ps[1:] {
+		if pos.Filename < first.Filename || pos.Filename == first.Filename && pos.Line < first.Line {
+			first = pos
+		}
+	}
+	return first, true
+}
+
 func imports(pkg *build.Package, isRoot bool) map[string]bool {
 	imps := make(map[string]bool)
 	addPackages(imps, pkg.Imports)
 	if isRoot && !*noTestDeps {
 		addPackages(imps, pkg.TestImports)
-		addPackages(imps, pkg.XTestImports)
+		if !xtestAsPackage() {
+			addPackages(imps, pkg.XTestImports)
+		}
 	}
 	return imps
 }
@@ -345,14 +1955,59 @@ func matchPattern(pattern string) func(name string) bool {
 	}
 }
 
+// stringsFlag implements flag.Value for
+// a flag that may be specified many times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func stringsVar(name, usage string) *stringsFlag {
+	var f stringsFlag
+	flag.Var(&f, name, usage)
+	return &f
+}
+
 func fatalf(f string, a ...interface{}) {
 	fmt.Fprintf(os.Stderr, "showdeps: %s", fmt.Sprintf(f, a...))
 	os.Exit(1)
 }
 
-func warningf(f string, a ...interface{}) {
+// warningf prints a warning and causes showdeps to exit with a
+// non-zero status. The kind classifies the warning and pkg holds the
+// package that it is about, if any; they are printed only when
+// -warnings-json is specified.
+func warningf(kind, pkg string, f string, a ...interface{}) {
 	exitCode = 1
-	fmt.Fprintf(os.Stderr, "showdeps: warning: %s", fmt.Sprintf(f, a...))
+	reportf(kind, pkg, f, a...)
+}
+
+// reportf is like warningf except that it does not
+// cause showdeps to fail.
+func reportf(kind, pkg string, f string, a ...interface{}) {
+	msg := fmt.Sprintf(f, a...)
+	if !*warnJSON {
+		fmt.Fprintf(os.Stderr, "showdeps: warning: %s", msg)
+		return
+	}
+	json.NewEncoder(os.Stderr).Encode(jsonWarning{
+		Type:    kind,
+		Message: strings.TrimSpace(msg),
+		Package: pkg,
+	})
+}
+
+// jsonWarning holds a warning as printed by -warnings-json.
+type jsonWarning struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Package string `json:"package,omitempty"`
 }
 
 func sorted(m map[string]bool) []string {
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// graphTest holds a dependency graph for testing, in the form
+// used by main before the root packages are deleted.
+type graphTest struct {
+	about string
+	// imports maps each package to the packages it imports.
+	imports map[string][]string
+	roots   []string
+	// testOnly holds the imports made only by test code.
+	testOnly []importEdge
+	// xtests maps synthetic external test packages
+	// to the packages they test.
+	xtests map[string]string
+}
+
+// setUp returns the graph as held in allPkgs and rootPkgs, setting
+// the testOnly and xtestPkgs globals to match. It returns a function
+// that restores them.
+func (test graphTest) setUp() (map[string][]string, map[string]bool, func()) {
+	oldTestOnly, oldXTests := testOnly, xtestPkgs
+	testOnly = make(map[importEdge]bool)
+	for _, e := range test.testOnly {
+		testOnly[e] = true
+	}
+	xtestPkgs = make(map[string]string)
+	for xtest, tested := range test.xtests {
+		xtestPkgs[xtest] = tested
+	}
+	allPkgs := make(map[string][]string)
+	for pkg, imps := range test.imports {
+		if _, ok := allPkgs[pkg]; !ok {
+			allPkgs[pkg] = nil
+		}
+		for _, imp := range imps {
+			allPkgs[imp] = append(allPkgs[imp], pkg)
+		}
+	}
+	rootPkgs := make(map[string]bool)
+	for _, root := range test.roots {
+		rootPkgs[root] = true
+	}
+	return allPkgs, rootPkgs, func() {
+		testOnly, xtestPkgs = oldTestOnly, oldXTests
+	}
+}
+
+var diamondGraph = graphTest{
+	about: "diamond",
+	imports: map[string][]string{
+		"r": {"a", "b"},
+		"a": {"c"},
+		"b": {"c"},
+		"c": {"d"},
+	},
+	roots: []string{"r"},
+}
+
+var sharedGraph = graphTest{
+	about: "shared dependency",
+	imports: map[string][]string{
+		"r": {"a", "b"},
+		"a": {"s", "x"},
+		"b": {"s"},
+	},
+	roots: []string{"r"},
+}
+
+var cycleGraph = graphTest{
+	about: "cycle",
+	imports: map[string][]string{
+		"r": {"a", "c"},
+		"a": {"b"},
+		"b": {"a", "c"},
+		"x": {"a"},
+	},
+	roots: []string{"r", "x"},
+}
+
+var xtestGraph = graphTest{
+	about: "external test package",
+	imports: map[string][]string{
+		"r":      {"q", "r_test"},
+		"r_test": {"r", "z"},
+		"z":      {"y"},
+	},
+	roots:    []string{"r"},
+	testOnly: []importEdge{{"r", "r_test"}},
+	xtests:   map[string]string{"r_test": "r"},
+}
+
+var importImpactTests = []struct {
+	graph  graphTest
+	expect string
+}{{
+	graph: diamondGraph,
+	expect: "" +
+		"r a 1 a\n" +
+		"r b 1 b\n",
+}, {
+	graph: sharedGraph,
+	expect: "" +
+		"r a 2 a x\n" +
+		"r b 1 b\n",
+}, {
+	graph: cycleGraph,
+	expect: "" +
+		"r a 0\n" +
+		"r c 0\n" +
+		"x a 0\n",
+}, {
+	graph: graphTest{
+		about: "cycle reached from one root",
+		imports: map[string][]string{
+			"r": {"a", "c"},
+			"a": {"b"},
+			"b": {"a"},
+		},
+		roots: []string{"r"},
+	},
+	expect: "" +
+		"r a 2 a b\n" +
+		"r c 1 c\n",
+}, {
+	graph: xtestGraph,
+	expect: "" +
+		"r_test z 2 y z\n" +
+		"r q 1 q\n",
+}}
+
+func TestImportImpact(t *testing.T) {
+	for _, test := range importImpactTests {
+		allPkgs, rootPkgs, restore := test.graph.setUp()
+		var buf bytes.Buffer
+		showImportImpact(&buf, allPkgs, rootPkgs)
+		restore()
+		if got := buf.String(); got != test.expect {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.graph.about, got, test.expect)
+		}
+	}
+}
+
+var minCutTests = []struct {
+	graph  graphTest
+	target string
+	// expect holds the expected cut, or nil if there
+	// is more than one minimum cut.
+	expect []importEdge
+	size   int
+}{{
+	graph:  diamondGraph,
+	target: "d",
+	expect: []importEdge{{"c", "d"}},
+	size:   1,
+}, {
+	graph:  diamondGraph,
+	target: "c",
+	size:   2,
+}, {
+	graph:  sharedGraph,
+	target: "s",
+	size:   2,
+}, {
+	graph:  cycleGraph,
+	target: "b",
+	size:   1,
+}, {
+	graph:  xtestGraph,
+	target: "z",
+	expect: []importEdge{{"r_test", "z"}},
+	size:   1,
+}}
+
+func TestMinCut(t *testing.T) {
+	for _, test := range minCutTests {
+		allPkgs, rootPkgs, restore := test.graph.setUp()
+		cut := minCut(allPkgs, rootPkgs, func(pkg string) bool {
+			return pkg == test.target
+		})
+		restore()
+		if len(cut) != test.size {
+			t.Errorf("%s, target %s: got cut %v, want size %d", test.graph.about, test.target, cut, test.size)
+		}
+		if test.expect != nil && !reflect.DeepEqual(cut, test.expect) {
+			t.Errorf("%s, target %s: got cut %v, want %v", test.graph.about, test.target, cut, test.expect)
+		}
+	}
+}
+
+var topoSortTests = []struct {
+	graph  graphTest
+	expect []string
+}{{
+	graph:  diamondGraph,
+	expect: []string{"d", "c", "a", "b"},
+}, {
+	graph:  sharedGraph,
+	expect: []string{"s", "b", "x", "a"},
+}, {
+	graph:  cycleGraph,
+	expect: []string{"c", "a", "b"},
+}}
+
+func TestTopoSort(t *testing.T) {
+	for _, test := range topoSortTests {
+		allPkgs, rootPkgs, restore := test.graph.setUp()
+		// Sort the packages as main does, after deleting the roots.
+		for root := range rootPkgs {
+			delete(allPkgs, root)
+		}
+		pkgs := sorted(pkgSet(allPkgs))
+		var nonRoot []string
+		for _, pkg := range pkgs {
+			if !rootPkgs[pkg] {
+				nonRoot = append(nonRoot, pkg)
+			}
+		}
+		got := topoSort(nonRoot, allPkgs)
+		restore()
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s: got %v, want %v", test.graph.about, got, test.expect)
+		}
+	}
+}
+
+func TestStronglyConnected(t *testing.T) {
+	pkgs := []string{"a", "b", "c", "x"}
+	imports := map[string][]string{
+		"a": {"b"},
+		"b": {"a", "c"},
+		"x": {"a"},
+	}
+	got := stronglyConnected(pkgs, imports)
+	expect := [][]string{{"c"}, {"a", "b"}, {"x"}}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %v, want %v", got, expect)
+	}
+}